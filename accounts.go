@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// account is an Emporia login with its own token and client.
+// Clients are not shared, so every account is rate limited separately.
+type account struct {
+	// name labels the account's series.  The default account, used without -accounts, is unnamed and unlabeled.
+	name string
+	// tenant, if set, is the VictoriaMetrics cluster tenant the account's series are written to instead of -dest-tenant.
+	tenant string
+	vue    *vueclient.Client
+	// budget, if set, limits the account's requests per day.
+	budget *requestBudget
+}
+
+var (
+	accountName   = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	accountTenant = regexp.MustCompile(`^[0-9]+(:[0-9]+)?$`)
+)
+
+// parseAccount splits an account as given to -accounts, name[@tenant].
+func parseAccount(s string) (name, tenant string, err error) {
+	name, tenant, hasTenant := strings.Cut(s, "@")
+	if !accountName.MatchString(name) {
+		return "", "", fmt.Errorf("invalid account name %q: use letters, digits, - and _", name)
+	}
+	if hasTenant && !accountTenant.MatchString(tenant) {
+		return "", "", fmt.Errorf("invalid tenant %q for account %s: use accountID[:projectID]", tenant, name)
+	}
+	return name, tenant, nil
+}
+
+// newAccounts creates a client for each account selected by -accounts.
+// The -username and -passwod flags are only used if there is a single account; others are prompted for.
+func newAccounts(configDir string) ([]*account, error) {
+	sealer, err := tokenSealer()
+	if err != nil {
+		return nil, err
+	}
+	if *accounts == "" {
+		vue, err := newVueClient(configDir, "", *username, *password, sealer)
+		if err != nil {
+			return nil, err
+		}
+		return withBudgets(configDir, []*account{{vue: vue}})
+	}
+	names := strings.Split(*accounts, ",")
+	var out []*account
+	for _, s := range names {
+		name, tenant, err := parseAccount(s)
+		if err != nil {
+			return nil, err
+		}
+		user, pass := "", ""
+		if len(names) == 1 {
+			user, pass = *username, *password
+		}
+		vue, err := newVueClient(configDir, name, user, pass, sealer)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", name, err)
+		}
+		out = append(out, &account{name: name, tenant: tenant, vue: vue})
+	}
+	return withBudgets(configDir, out)
+}
+
+// withBudgets opens the accounts' request budgets if -daily-budget is set.
+func withBudgets(configDir string, accts []*account) ([]*account, error) {
+	if *dailyBudget <= 0 {
+		return accts, nil
+	}
+	for _, a := range accts {
+		b, err := openBudget(filepath.Join(accountDir(configDir, a.name), "budget.json"), *dailyBudget)
+		if err != nil {
+			return nil, a.wrap(err)
+		}
+		a.budget = b
+	}
+	return accts, nil
+}
+
+// accountDir returns the directory holding the named account's state.
+func accountDir(configDir, name string) string {
+	if name == "" {
+		return filepath.Join(configDir, "vuescrape")
+	}
+	return filepath.Join(configDir, "vuescrape", "accounts", name)
+}
+
+// wrap prefixes an error with the account name, if there is one.
+func (a *account) wrap(err error) error {
+	if err == nil || a.name == "" {
+		return err
+	}
+	return fmt.Errorf("account %s: %w", a.name, err)
+}
+
+// closeAccounts releases the accounts' resources, warning about tokens that could not be saved.
+func closeAccounts(accts []*account) error {
+	var errs []error
+	for _, a := range accts {
+		if err := a.vue.Tokens.SaveErr(); err != nil {
+			log.Printf("warning: %v; the next run will need to sign in again", a.wrap(fmt.Errorf("token not saved: %w", err)))
+		}
+		errs = append(errs, a.wrap(a.vue.Archive.Close()))
+	}
+	return errors.Join(errs...)
+}
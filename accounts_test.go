@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"sgrankin.dev/vuescrape/vmclient"
+)
+
+func TestNewAccounts(t *testing.T) {
+	for _, tt := range []struct {
+		accounts string
+		want     []string // name@tenant
+		wantErr  bool
+	}{
+		{"", []string{"@"}, false},
+		{"home", []string{"home@"}, false},
+		{"home,cabin_2,rental-3", []string{"home@", "cabin_2@", "rental-3@"}, false},
+		{"home@1,cabin@2:0,rental", []string{"home@1", "cabin@2:0", "rental@"}, false},
+		{"home,", nil, true},
+		{"home,the cabin", nil, true},
+		{"../home", nil, true},
+		{"@1", nil, true},
+		{"home@", nil, true},
+		{"home@one", nil, true},
+		{"home@1:2:3", nil, true},
+	} {
+		t.Run(tt.accounts, func(t *testing.T) {
+			setFlag(t, "accounts", tt.accounts)
+			accts, err := newAccounts(t.TempDir())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newAccounts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, a := range accts {
+				got = append(got, a.name+"@"+a.tenant)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("newAccounts() names diff (-want+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAccountDir(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"", "/config/vuescrape"},
+		{"home", "/config/vuescrape/accounts/home"},
+	} {
+		if got := accountDir("/config", tt.name); got != filepath.FromSlash(tt.want) {
+			t.Errorf("accountDir(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRun_Tenants(t *testing.T) {
+	var mu sync.Mutex
+	imports := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/api/v1/query") {
+			w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+			return
+		}
+		mu.Lock()
+		imports[r.URL.Path]++
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	vm := newVMDestination(&vmclient.Client{Dest: *u, TenantID: "0"}, "")
+	shared := &memSink{}
+	dsts := []destination{vm, {name: "mem", Sink: shared}}
+
+	home, cabin, rental := newTestAccount(t, "home", nil), newTestAccount(t, "cabin", nil), newTestAccount(t, "rental", nil)
+	home.tenant, cabin.tenant = "1", "2:0"
+	byTenant := destinationsByTenant(dsts, []*account{home, cabin, rental})
+	if err := run([]*account{home, cabin, rental}, byTenant, time.Hour); err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+
+	var got []string
+	for path := range imports {
+		got = append(got, path)
+	}
+	slices.Sort(got)
+	want := []string{
+		"/insert/0/prometheus/api/v1/import",
+		"/insert/1/prometheus/api/v1/import",
+		"/insert/2:0/prometheus/api/v1/import",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("import paths diff (-want+got):\n%s", diff)
+	}
+	accounts := map[string]bool{}
+	for _, s := range shared.series {
+		accounts[s.Metric.Labels["account"]] = true
+	}
+	if diff := cmp.Diff(map[string]bool{"home": true, "cabin": true, "rental": true}, accounts); diff != "" {
+		t.Errorf("accounts written to the shared destination diff (-want+got):\n%s", diff)
+	}
+	if dsts[0].vm.TenantID != "0" {
+		t.Errorf("default destination tenant = %q, want it unchanged", dsts[0].vm.TenantID)
+	}
+}
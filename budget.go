@@ -0,0 +1,55 @@
+package main
+
+import (
+	"time"
+
+	"sgrankin.dev/vuescrape/internal/jsondb"
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// requestBudget limits an account's requests to Emporia per day, counting them in a file shared by runs.
+// Runs that overlap may together exceed it; see -exclusive.
+type requestBudget struct {
+	db    *jsondb.DB[budgetUsage]
+	limit int
+}
+
+type budgetUsage struct {
+	Day      string `json:"day"` // Local date the requests were made on.
+	Requests int    `json:"requests"`
+}
+
+func openBudget(path string, limit int) (*requestBudget, error) {
+	db, err := jsondb.OpenWith(path, jsondb.Options[budgetUsage]{Version: 1})
+	if err != nil {
+		return nil, err
+	}
+	return &requestBudget{db, limit}, nil
+}
+
+// start limits vue to what is left of the budget for the day of now.
+// The returned function records the requests made since.
+func (b *requestBudget) start(vue *vueclient.Client, now time.Time) (done func() error, err error) {
+	day := now.Format(time.DateOnly)
+	used := 0
+	if err := b.db.Update(func(u *budgetUsage) error {
+		if u.Day == day {
+			used = u.Requests
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	vue.Throttle.SetBudget(max(b.limit-used, 0))
+	before := vue.Throttle.Requests()
+	return func() error {
+		n := vue.Throttle.Requests() - before
+		return b.db.Update(func(u *budgetUsage) error {
+			if u.Day != day {
+				*u = budgetUsage{Day: day}
+			}
+			u.Requests += n
+			return nil
+		})
+	}, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+func TestRequestBudget(t *testing.T) {
+	a := newTestAccount(t, "", nil)
+	path := filepath.Join(t.TempDir(), "budget.json")
+	b, err := openBudget(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+
+	// run starts a run at now, makes n requests, and checks what was left of the budget before them.
+	run := func(now time.Time, n, wantRemaining int) {
+		t.Helper()
+		done, err := b.start(a.vue, now)
+		if err != nil {
+			t.Fatalf("start() error = %v", err)
+		}
+		if got, limited := a.vue.Throttle.Remaining(); got != wantRemaining || !limited {
+			t.Errorf("Remaining() at %v = %d, %v, want %d, true", now, got, limited, wantRemaining)
+		}
+		for i := range n {
+			_, err := a.vue.GetDevices()
+			if i < wantRemaining && err != nil {
+				t.Fatalf("request %d error = %v", i+1, err)
+			}
+			if i >= wantRemaining && !errors.Is(err, vueclient.ErrBudgetSpent) {
+				t.Fatalf("request %d error = %v, want ErrBudgetSpent", i+1, err)
+			}
+		}
+		if err := done(); err != nil {
+			t.Fatalf("done() error = %v", err)
+		}
+	}
+	run(day, 2, 5)
+	run(day.Add(time.Hour), 4, 3) // Only the 3 requests made are counted.
+	run(day.Add(2*time.Hour), 1, 0)
+	run(day.Add(24*time.Hour), 1, 5)
+
+	// Usage is kept in the file, for later runs.
+	b, err = openBudget(path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (budgetUsage{Day: "2024-03-02", Requests: 1}); *b.db.Data != want {
+		t.Errorf("usage = %+v, want %+v", *b.db.Data, want)
+	}
+}
+
+func TestRunAccount_BudgetSpent(t *testing.T) {
+	a := newTestAccount(t, "", nil)
+	var err error
+	// Enough for the devices, but not their status or history.
+	if a.budget, err = openBudget(filepath.Join(t.TempDir(), "budget.json"), 1); err != nil {
+		t.Fatal(err)
+	}
+	dst := &memSink{}
+	if err := runAccount(a, []destination{{name: "mem", Sink: dst}}, time.Hour); err != nil {
+		t.Errorf("runAccount() error = %v, want the rest deferred", err)
+	}
+	if got := len(dst.samples()); got != 0 {
+		t.Errorf("runAccount() wrote %d samples, want none", got)
+	}
+	if got := a.budget.db.Data.Requests; got != 1 {
+		t.Errorf("requests counted = %d, want 1", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// runEVCharger implements the evcharger command: it lists the EV chargers of every account with their state,
+// or pauses or resumes charging on one of them.
+func runEVCharger(configDir string, args []string) (err error) {
+	fs := flag.NewFlagSet("evcharger", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] evcharger [-amps=N] [DEVICE_GID pause|resume]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	amps := fs.Int("amps", 0, "If set, the charging rate in `amps` to resume at.  It must not exceed the charger's maximum.")
+	fs.Parse(args)
+	var gid vueclient.DeviceGID
+	var on bool
+	switch fs.NArg() {
+	case 0:
+	case 2:
+		n, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("evcharger: bad device GID %q", fs.Arg(0))
+		}
+		gid = vueclient.DeviceGID(n)
+		switch fs.Arg(1) {
+		case "resume":
+			on = true
+		case "pause":
+		default:
+			return fmt.Errorf("evcharger: action must be pause or resume, not %q", fs.Arg(1))
+		}
+	default:
+		fs.Usage()
+		return errors.New("evcharger: want no arguments or a device GID and an action")
+	}
+	ampsSet := false
+	fs.Visit(func(f *flag.Flag) { ampsSet = ampsSet || f.Name == "amps" })
+	if ampsSet && !on {
+		return errors.New("evcharger: -amps is only used to resume")
+	}
+	if ampsSet && *amps <= 0 {
+		return fmt.Errorf("evcharger: -amps must be positive, not %d", *amps)
+	}
+
+	accts, err := newAccounts(configDir)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeAccounts(accts)) }()
+	var errs []error
+	for _, a := range accts {
+		chargers, err := a.vue.GetEVChargers()
+		if err != nil {
+			errs = append(errs, a.wrap(err))
+			continue
+		}
+		for _, ch := range chargers {
+			if gid == 0 {
+				printEVCharger(a, &ch)
+				continue
+			}
+			if ch.DeviceGID != gid {
+				continue
+			}
+			// Device GIDs are unique across accounts, so this is the only charger to update.
+			ch.ChargerOn = on
+			if ampsSet {
+				if *amps > ch.MaxChargingRate {
+					return a.wrap(fmt.Errorf("evcharger: -amps=%d exceeds the maximum of %dA for charger %d", *amps, ch.MaxChargingRate, gid))
+				}
+				ch.ChargingRate = *amps
+			}
+			updated, err := a.vue.UpdateEVCharger(&ch)
+			if err != nil {
+				return a.wrap(err)
+			}
+			printEVCharger(a, updated)
+			return nil
+		}
+	}
+	if gid != 0 {
+		errs = append(errs, fmt.Errorf("evcharger: no EV charger %d", gid))
+	}
+	return errors.Join(errs...)
+}
+
+// printEVCharger prints the state of a charger as DEVICE_GID charging|paused RATE/MAX_RATE STATUS,
+// preceded by the account if it is named.
+func printEVCharger(a *account, ch *vueclient.EVCharger) {
+	state := "paused"
+	if ch.ChargerOn {
+		state = "charging"
+	}
+	if a.name != "" {
+		fmt.Printf("%s\t", a.name)
+	}
+	fmt.Printf("%d\t%s\t%d/%dA\t%s\n", ch.DeviceGID, state, ch.ChargingRate, ch.MaxChargingRate, ch.Status)
+}
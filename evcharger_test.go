@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"sgrankin.dev/vuescrape/vueclient/vuefake"
+)
+
+func TestRunEVCharger(t *testing.T) {
+	configDir := useFakeServer(t, &vuefake.Server{})
+	for _, tt := range []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{nil, "1002\tcharging\t32/40A\tCharging\n", false},
+		{[]string{"1002", "pause"}, "1002\tpaused\t32/40A\tStandby\n", false},
+		{[]string{"-amps=16", "1002", "resume"}, "1002\tcharging\t16/40A\tCharging\n", false},
+		{nil, "1002\tcharging\t16/40A\tCharging\n", false},
+		{[]string{"-amps=0", "1002", "resume"}, "", true},
+		{[]string{"-amps=-6", "1002", "resume"}, "", true},
+		{[]string{"-amps=41", "1002", "resume"}, "", true},
+		{[]string{"-amps=16", "1002", "pause"}, "", true},
+		{[]string{"1001", "pause"}, "", true},
+	} {
+		var err error
+		got := stdout(t, func() { err = runEVCharger(configDir, tt.args) })
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("evcharger %q error = %v, wantErr %v", tt.args, err, tt.wantErr)
+
+		}
+		if got != tt.want {
+			t.Errorf("evcharger %q printed %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
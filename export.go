@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"sgrankin.dev/vuescrape/internal/atomicfile"
+	"sgrankin.dev/vuescrape/internal/cursor"
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// runExport implements the export command: channel history is written to files instead of a time series database.
+//
+// Files are laid out as OUT/[date=PARTITION/]DEVICE-CHANNEL.{csv,parquet}.
+// A cursor file in OUT records the last exported sample of each channel, so repeated runs only add new rows.
+// CSV files are appended to; as Parquet files can't be, every run writes new Parquet files named after their first row.
+func runExport(configDir string, args []string) (err error) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", "csv", "Output `format`: csv or parquet.")
+	out := fs.String("out", "", "Output directory.")
+	partition := fs.String("partition", "none", "Split files by `period`: none, day or month (UTC).")
+	scale := fs.String("scale", string(vueclient.Scale1Minute), "Sample `scale`: 1S, 1MIN or 1H.")
+	unit := fs.String("unit", string(vueclient.EnergyKWh), "Energy `unit`, e.g. KilowattHours or AmpHours.")
+	fs.Parse(args)
+
+	if *out == "" {
+		return errors.New("export: -out is required")
+	}
+	if *format != "csv" && *format != "parquet" {
+		return fmt.Errorf("export: unknown -format %q", *format)
+	}
+	partitionFormat, ok := map[string]string{"none": "", "day": "2006-01-02", "month": "2006-01"}[*partition]
+	if !ok {
+		return fmt.Errorf("export: unknown -partition %q", *partition)
+	}
+	if _, ok := vueclient.Scale(*scale).LookupPageSize(); !ok {
+		return fmt.Errorf("export: unsupported -scale %q", *scale)
+	}
+	cur, err := cursor.Open(filepath.Join(*out, ".vuescrape-export.json"))
+	if err != nil {
+		return err
+	}
+	accts, err := newAccounts(configDir)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeAccounts(accts)) }()
+	e := &exporter{
+		cur:             cur,
+		out:             *out,
+		format:          *format,
+		partitionFormat: partitionFormat,
+		scale:           vueclient.Scale(*scale),
+		unit:            vueclient.EnergyUnit(*unit),
+	}
+	until := time.Now()
+	since := until.Add(-*lookback)
+	var errs []error
+	// Device GIDs are unique across accounts, so all accounts share the output directory.
+	for _, a := range accts {
+		devs, err := a.vue.GetDevices()
+		if err != nil {
+			errs = append(errs, a.wrap(err))
+			continue
+		}
+		e.vue = a.vue
+		for _, ch := range channels(devs) {
+			errs = append(errs, a.wrap(e.export(ch, since, until)))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type exporter struct {
+	vue             *vueclient.Client
+	cur             *cursor.File
+	out             string
+	format          string
+	partitionFormat string // Go time layout naming the partition, or empty for no partitioning.
+	scale           vueclient.Scale
+	unit            vueclient.EnergyUnit
+}
+
+// exportRow is a single exported sample.
+type exportRow struct {
+	Timestamp time.Time `parquet:"timestamp,timestamp(millisecond)"`
+	DeviceGID int64     `parquet:"device_gid"`
+	Channel   string    `parquet:"channel"`
+	Name      string    `parquet:"name"`
+	Scale     string    `parquet:"scale"`
+	Unit      string    `parquet:"unit"`
+	Value     float64   `parquet:"value"`
+}
+
+var csvHeader = []string{"timestamp", "device_gid", "channel", "name", "scale", "unit", "value"}
+
+func (r *exportRow) csv() []string {
+	return []string{
+		r.Timestamp.UTC().Format(time.RFC3339),
+		strconv.FormatInt(r.DeviceGID, 10),
+		r.Channel,
+		r.Name,
+		r.Scale,
+		r.Unit,
+		strconv.FormatFloat(r.Value, 'g', -1, 64),
+	}
+}
+
+// export writes the new history of a channel.
+func (e *exporter) export(ch vueclient.Channel, since, until time.Time) error {
+	key := fmt.Sprintf("%d/%s/%s/%s", ch.DeviceGID, ch.ChannelNum, e.scale, e.unit)
+	if last := e.cur.Last(key); !last.IsZero() {
+		if next := last.Add(e.scale.Duration()); next.After(since) {
+			since = next
+		}
+	}
+	if !since.Before(until) {
+		return nil
+	}
+	start, found, err := e.vue.GetHistory(ch.DeviceGID, ch.ChannelNum, since, until, e.scale, e.unit)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	// Group rows into files.
+	var files []string
+	rows := map[string][]exportRow{}
+	for _, v := range found {
+		ts := start
+		start = start.Add(e.scale.Duration())
+		if v == nil {
+			continue
+		}
+		path := e.path(ch, ts)
+		if _, ok := rows[path]; !ok {
+			files = append(files, path)
+		}
+		rows[path] = append(rows[path], exportRow{
+			Timestamp: ts,
+			DeviceGID: int64(ch.DeviceGID),
+			Channel:   ch.ChannelNum,
+			Name:      ch.Name,
+			Scale:     string(e.scale),
+			Unit:      string(e.unit),
+			Value:     *v,
+		})
+	}
+	n := 0
+	for _, path := range files {
+		write := appendCSV
+		if e.format == "parquet" {
+			write = writeParquet
+		}
+		if err := write(path, rows[path]); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		n += len(rows[path])
+		// Advance after every file so that a failure doesn't cause earlier files to be written again.
+		last := rows[path][len(rows[path])-1].Timestamp
+		if err := e.cur.Advance(key, last); err != nil {
+			return err
+		}
+	}
+	log.Printf("channel %s exported %d new rows to %d files", key, n, len(files))
+	return nil
+}
+
+// path is the file a sample at ts belongs in, without the extension for Parquet files.
+func (e *exporter) path(ch vueclient.Channel, ts time.Time) string {
+	dir := e.out
+	if e.partitionFormat != "" {
+		dir = filepath.Join(dir, "date="+ts.UTC().Format(e.partitionFormat))
+	}
+	name := fmt.Sprintf("%d-%s", ch.DeviceGID, strings.ReplaceAll(ch.ChannelNum, ",", "_"))
+	if e.format == "csv" {
+		name += ".csv"
+	}
+	return filepath.Join(dir, name)
+}
+
+// appendCSV appends rows to the CSV file at path, creating it with a header if needed.
+// Rows that are not newer than the last row already in the file are skipped.
+func appendCSV(path string, rows []exportRow) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	last, err := lastCSVTimestamp(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	w := csv.NewWriter(f)
+	if last == nil {
+		if err := w.Write(csvHeader); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	for _, r := range rows {
+		if last != nil && !r.Timestamp.After(*last) {
+			continue
+		}
+		if err := w.Write(r.csv()); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// lastCSVTimestamp returns the timestamp of the last row in an export CSV file, or nil if the file is empty.
+func lastCSVTimestamp(f *os.File) (*time.Time, error) {
+	fi, err := f.Stat()
+	if err != nil || fi.Size() == 0 {
+		return nil, err
+	}
+	// Rows are short; the last one is within the tail of the file.
+	off := max(0, fi.Size()-4096)
+	tail := make([]byte, fi.Size()-off)
+	if _, err := f.ReadAt(tail, off); err != nil && err != io.EOF {
+		return nil, err
+	}
+	lines := bytes.Split(bytes.TrimRight(tail, "\n"), []byte("\n"))
+	field, _, _ := strings.Cut(string(lines[len(lines)-1]), ",")
+	if field == csvHeader[0] {
+		t := time.Time{}
+		return &t, nil
+	}
+	t, err := time.Parse(time.RFC3339, field)
+	if err != nil {
+		return nil, fmt.Errorf("last row: %w", err)
+	}
+	return &t, nil
+}
+
+// writeParquet writes rows to a new Parquet file named after path and the first row's timestamp.
+// Rerunning an interrupted export rewrites the same file.
+func writeParquet(path string, rows []exportRow) error {
+	path = fmt.Sprintf("%s-%d.parquet", path, rows[0].Timestamp.Unix())
+	buf := &bytes.Buffer{}
+	w := parquet.NewGenericWriter[exportRow](buf)
+	if _, err := w.Write(rows); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, buf.Bytes(), 0644)
+}
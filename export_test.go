@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/parquet-go/parquet-go"
+
+	"sgrankin.dev/vuescrape/internal/cursor"
+	"sgrankin.dev/vuescrape/vueclient"
+	"sgrankin.dev/vuescrape/vueclient/vuefake"
+)
+
+func testRows(start time.Time, n int) []exportRow {
+	var rows []exportRow
+	for i := range n {
+		rows = append(rows, exportRow{
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			DeviceGID: 1000,
+			Channel:   "1",
+			Name:      "Circuit 1",
+			Scale:     string(vueclient.Scale1Minute),
+			Unit:      string(vueclient.EnergyKWh),
+			Value:     float64(i) + 0.5,
+		})
+	}
+	return rows
+}
+
+func TestAppendCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "date=2024-03-01", "1000-1.csv")
+	rows := testRows(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 4)
+	if err := appendCSV(path, rows[:2]); err != nil {
+		t.Fatalf("appendCSV() error = %v", err)
+	}
+	// Rows already in the file are skipped.
+	if err := appendCSV(path, rows[1:]); err != nil {
+		t.Fatalf("appendCSV() to an existing file error = %v", err)
+	}
+
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `timestamp,device_gid,channel,name,scale,unit,value
+2024-03-01T00:00:00Z,1000,1,Circuit 1,1MIN,KilowattHours,0.5
+2024-03-01T00:01:00Z,1000,1,Circuit 1,1MIN,KilowattHours,1.5
+2024-03-01T00:02:00Z,1000,1,Circuit 1,1MIN,KilowattHours,2.5
+2024-03-01T00:03:00Z,1000,1,Circuit 1,1MIN,KilowattHours,3.5
+`
+	if diff := cmp.Diff(want, string(bs)); diff != "" {
+		t.Errorf("file diff (-want+got):\n%s", diff)
+	}
+}
+
+func TestLastCSVTimestamp(t *testing.T) {
+	zero := time.Time{}
+	last := time.Date(2024, 3, 1, 0, 1, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		name    string
+		file    string
+		want    *time.Time
+		wantErr bool
+	}{
+		{"empty", "", nil, false},
+		{"header", "timestamp,device_gid,channel,name,scale,unit,value\n", &zero, false},
+		{"rows", "timestamp,device_gid,channel,name,scale,unit,value\n" +
+			"2024-03-01T00:00:00Z,1000,1,Circuit 1,1MIN,KilowattHours,0.5\n" +
+			"2024-03-01T00:01:00Z,1000,1,Circuit 1,1MIN,KilowattHours,1.5\n", &last, false},
+		{"long", "timestamp,device_gid,channel,name,scale,unit,value\n" +
+			strings.Repeat("2024-03-01T00:00:00Z,1000,1,Circuit 1,1MIN,KilowattHours,0.5\n", 1000) +
+			"2024-03-01T00:01:00Z,1000,1,Circuit 1,1MIN,KilowattHours,1.5", &last, false},
+		{"corrupt", "timestamp,device_gid,channel,name,scale,unit,value\nyesterday,1000\n", nil, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "1000-1.csv")
+			if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			got, err := lastCSVTimestamp(f)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lastCSVTimestamp() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("lastCSVTimestamp() diff (-want+got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteParquet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "1000-1")
+	rows := testRows(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 3)
+	if err := writeParquet(path, rows); err != nil {
+		t.Fatalf("writeParquet() error = %v", err)
+	}
+	got, err := parquet.ReadFile[exportRow](path + "-1709251200.parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(rows, got); diff != "" {
+		t.Errorf("rows read back diff (-want+got):\n%s", diff)
+	}
+}
+
+func TestRunExport_Scale(t *testing.T) {
+	err := runExport(t.TempDir(), []string{"-out", t.TempDir(), "-scale", "1D"})
+	if err == nil || !strings.Contains(err.Error(), "-scale") {
+		t.Errorf("runExport(-scale=1D) error = %v, want unsupported -scale", err)
+	}
+}
+
+func TestExporter_Partitions(t *testing.T) {
+	a := newTestAccount(t, "", nil)
+	out := t.TempDir()
+	cur, err := cursor.Open(filepath.Join(out, ".vuescrape-export.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &exporter{
+		vue:             a.vue,
+		cur:             cur,
+		out:             out,
+		format:          "csv",
+		partitionFormat: "2006-01-02",
+		scale:           vueclient.Scale1Minute,
+		unit:            vueclient.EnergyKWh,
+	}
+	ch := vueclient.Channel{DeviceGID: 1000, ChannelNum: "1", Name: "Circuit 1", ChannelMultiplier: 1}
+
+	// Two runs, across midnight; the second only appends what is new since the first.
+	midnight := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	since := midnight.Add(-5 * time.Minute)
+	for _, until := range []time.Time{midnight.Add(2 * time.Minute), midnight.Add(5 * time.Minute)} {
+		if err := e.export(ch, since, until); err != nil {
+			t.Fatalf("export() until %v error = %v", until, err)
+		}
+	}
+
+	want := map[string][]string{}
+	for ts := since; ts.Before(midnight.Add(5 * time.Minute)); ts = ts.Add(time.Minute) {
+		if vuefake.Usage(ch.DeviceGID, ch.ChannelNum, ts, time.Minute) == nil {
+			continue
+		}
+		file := filepath.Join("date="+ts.Format("2006-01-02"), "1000-1.csv")
+		want[file] = append(want[file], ts.Format(time.RFC3339))
+	}
+	got := map[string][]string{}
+	for _, file := range []string{"date=2024-02-29/1000-1.csv", "date=2024-03-01/1000-1.csv"} {
+		f, err := os.Open(filepath.Join(out, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if diff := cmp.Diff(csvHeader, records[0]); diff != "" {
+			t.Errorf("%s header diff (-want+got):\n%s", file, diff)
+		}
+		for _, r := range records[1:] {
+			got[filepath.FromSlash(file)] = append(got[filepath.FromSlash(file)], r[0])
+		}
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("exported timestamps diff (-want+got):\n%s", diff)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"sgrankin.dev/vuescrape/vueclient"
+	"sgrankin.dev/vuescrape/vueclient/cognitofake"
+	"sgrankin.dev/vuescrape/vueclient/vuefake"
+)
+
+// runFakeServer implements the fake-server command, serving a fake Emporia API for development and demos.
+// Cognito requests, which are told apart by their X-Amz-Target header, are served by a fake user pool.
+func runFakeServer(args []string) error {
+	fs := flag.NewFlagSet("fake-server", flag.ExitOnError)
+	listen := fs.String("listen", "localhost:8080", "Address to listen on.")
+	user := fs.String("user", "user", "User name of the fake account.")
+	password := fs.String("password", "password", "Password of the fake account.")
+	mfaCode := fs.String("mfa-code", "", "If set, the authenticator app `code` the fake account must give after its password.")
+	fs.Parse(args)
+
+	log.Printf("serving fake Emporia and Cognito APIs on http://%s", *listen)
+	return http.ListenAndServe(*listen, newFakeServer(*user, *password, *mfaCode, &vuefake.Server{}))
+}
+
+// newFakeServer serves api, and a fake user pool with a single user to sign in to it.
+// If mfaCode is set, the user must give it after their password.
+func newFakeServer(user, password, mfaCode string, api *vuefake.Server) http.Handler {
+	def := vueclient.DefaultCognito()
+	idp := &cognitofake.Server{
+		UserPool: def.UserPool,
+		ClientID: def.ClientID,
+		Users:    map[string]string{user: password},
+	}
+	if mfaCode != "" {
+		idp.MFA = map[string]string{user: mfaCode}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "" {
+			idp.ServeHTTP(w, r)
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+}
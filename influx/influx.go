@@ -0,0 +1,241 @@
+// Package influx writes series as InfluxDB line protocol.
+//
+// Lines can be sent to an InfluxDB 2 compatible /api/v2/write endpoint (InfluxDB, QuestDB, ...) or written to a file.
+// See the [docs] for details.
+//
+// [docs]: https://docs.influxdata.com/influxdb/v2/reference/syntax/line-protocol/
+package influx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sgrankin.dev/vuescrape/vmclient"
+)
+
+// Precision is the unit of line timestamps.
+type Precision string
+
+const (
+	PrecisionNanosecond  Precision = "ns"
+	PrecisionMicrosecond Precision = "us"
+	PrecisionMillisecond Precision = "ms"
+	PrecisionSecond      Precision = "s"
+)
+
+// ParsePrecision validates a precision name.
+func ParsePrecision(s string) (Precision, error) {
+	switch p := Precision(s); p {
+	case PrecisionNanosecond, PrecisionMicrosecond, PrecisionMillisecond, PrecisionSecond:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown precision %q", s)
+}
+
+func (p Precision) timestamp(t time.Time) int64 {
+	switch p {
+	case PrecisionMicrosecond:
+		return t.UnixMicro()
+	case PrecisionMillisecond:
+		return t.UnixMilli()
+	case PrecisionSecond:
+		return t.Unix()
+	default:
+		return t.UnixNano()
+	}
+}
+
+// Client writes to an InfluxDB 2 write endpoint.
+type Client struct {
+	// URL is the server base URL, e.g. http://influxdb:8086.
+	URL url.URL
+	// Org and Bucket select where data is written.
+	Org    string
+	Bucket string
+	// Token, if set, is sent as the API token.
+	Token string
+	// Precision is the unit of written timestamps.
+	// If empty, seconds are used; Vue samples are never finer than that.
+	Precision Precision
+
+	// HTTPClient is used to make requests.
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+// Options configures a [Pusher].
+// Zero values select the defaults.
+type Options struct {
+	// MaxLines bounds the number of lines in a single write.
+	MaxLines int // Default: 5000.
+
+	// RetryPolicy configures how a failed request to a server is resent.
+	vmclient.RetryPolicy
+}
+
+func (o *Options) withDefaults() Options {
+	out := *o
+	if out.MaxLines <= 0 {
+		out.MaxLines = 5000
+	}
+	return out
+}
+
+// Pusher batches series into line protocol writes.
+type Pusher struct {
+	precision Precision
+	opts      Options
+	send      func([]byte) error
+
+	buf     bytes.Buffer
+	lines   int
+	pending vmclient.PushStats
+	sent    vmclient.PushStats
+}
+
+// Push starts a batch of writes to the server.
+func (c *Client) Push(opts Options) *Pusher {
+	opts = opts.withDefaults()
+	return &Pusher{
+		precision: c.precision(),
+		opts:      opts,
+		send: func(b []byte) error {
+			return opts.RetryPolicy.Do("influx write", func() error { return c.write(b) })
+		},
+	}
+}
+
+// NewWriter returns a Pusher that writes batches to w instead of a server.
+func NewWriter(w io.Writer, precision Precision, opts Options) *Pusher {
+	if precision == "" {
+		precision = PrecisionSecond
+	}
+	return &Pusher{
+		precision: precision,
+		opts:      opts.withDefaults(),
+		send: func(b []byte) error {
+			_, err := w.Write(b)
+			return err
+		},
+	}
+}
+
+// Push adds a line per sample to the current batch, sending the batch if it is full.
+func (p *Pusher) Push(s *vmclient.Series) error {
+	prefix := linePrefix(&s.Metric)
+	n := 0 // Lines of s in the current batch.
+	for _, sample := range s.Samples {
+		p.buf.WriteString(prefix)
+		p.buf.WriteString(" value=")
+		p.buf.WriteString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+		p.buf.WriteByte(' ')
+		p.buf.WriteString(strconv.FormatInt(p.precision.timestamp(sample.Timestamp), 10))
+		p.buf.WriteByte('\n')
+		p.lines++
+		n++
+		if p.lines >= p.opts.MaxLines {
+			p.pending.Series++
+			p.pending.Samples += n
+			n = 0
+			if err := p.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if n > 0 {
+		p.pending.Series++
+		p.pending.Samples += n
+	}
+	return nil
+}
+
+// Flush sends the current batch.
+func (p *Pusher) Flush() error {
+	if p.buf.Len() == 0 {
+		return nil
+	}
+	body := bytes.Clone(p.buf.Bytes())
+	pending := p.pending
+	p.buf.Reset()
+	p.lines = 0
+	p.pending = vmclient.PushStats{}
+
+	if err := p.send(body); err != nil {
+		return err
+	}
+	p.sent.Series += pending.Series
+	p.sent.Samples += pending.Samples
+	return nil
+}
+
+// Close flushes any buffered lines.
+func (p *Pusher) Close() error {
+	if p == nil {
+		return nil
+	}
+	return p.Flush()
+}
+
+// Stats returns the counts of data acknowledged by the server.
+func (p *Pusher) Stats() vmclient.PushStats {
+	return p.sent
+}
+
+func (c *Client) precision() Precision {
+	if c.Precision == "" {
+		return PrecisionSecond
+	}
+	return c.Precision
+}
+
+func (c *Client) write(body []byte) error {
+	v := url.Values{}
+	v.Set("org", c.Org)
+	v.Set("bucket", c.Bucket)
+	v.Set("precision", string(c.precision()))
+	u := c.URL.JoinPath("/api/v2/write")
+	u.RawQuery = v.Encode()
+
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Token "+c.Token)
+	}
+	return vmclient.Send(c.HTTPClient, req)
+}
+
+// linePrefix formats the measurement and tag set of a metric.
+// Labels become tags, sorted by key; empty labels are dropped as line protocol does not allow them.
+func linePrefix(m *vmclient.Metric) string {
+	var b strings.Builder
+	b.WriteString(measurementEscaper.Replace(m.Name))
+	keys := make([]string, 0, len(m.Labels))
+	for k, v := range m.Labels {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		b.WriteByte(',')
+		b.WriteString(tagEscaper.Replace(k))
+		b.WriteByte('=')
+		b.WriteString(tagEscaper.Replace(m.Labels[k]))
+	}
+	return b.String()
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "\n", `\n`)
+	tagEscaper         = strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`)
+)
@@ -0,0 +1,134 @@
+package influx
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"sgrankin.dev/vuescrape/vmclient"
+)
+
+func TestWriter(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision Precision
+		series    vmclient.Series
+		want      string
+	}{
+		{"plain", PrecisionSecond, vmclient.Series{
+			Metric: vmclient.Metric{Name: "vue_kwh", Labels: map[string]string{"dev_gid": "1", "chan": "1,2,3", "name": ""}},
+			Samples: []vmclient.Sample{
+				{Value: 0.25, Timestamp: time.Unix(60, 0)},
+				{Value: 2, Timestamp: time.Unix(120, 0)}}},
+			"vue_kwh,chan=1\\,2\\,3,dev_gid=1 value=0.25 60\n" +
+				"vue_kwh,chan=1\\,2\\,3,dev_gid=1 value=2 120\n"},
+		{"escaped", PrecisionMillisecond, vmclient.Series{
+			Metric:  vmclient.Metric{Name: "vue kwh", Labels: map[string]string{"name": "Hot Tub=on"}},
+			Samples: []vmclient.Sample{{Value: 1e-7, Timestamp: time.Unix(60, 0)}}},
+			"vue\\ kwh,name=Hot\\ Tub\\=on value=1e-07 60000\n"},
+		{"backslash", PrecisionSecond, vmclient.Series{
+			Metric:  vmclient.Metric{Name: "vue_kwh", Labels: map[string]string{"name": `C:\ `}},
+			Samples: []vmclient.Sample{{Value: 1, Timestamp: time.Unix(60, 0)}}},
+			"vue_kwh,name=C:\\\\\\  value=1 60\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			p := NewWriter(&buf, tt.precision, Options{MaxLines: 1})
+			if err := p.Push(&tt.series); err != nil {
+				t.Fatalf("Push() error = %v", err)
+			}
+			if err := p.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if got := p.Stats().Samples; got != len(tt.series.Samples) {
+				t.Errorf("Stats().Samples = %d, want %d", got, len(tt.series.Samples))
+			}
+		})
+	}
+}
+
+func TestPusher(t *testing.T) {
+	var got []string
+	failures := 1
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" {
+			t.Errorf("path = %q, want /api/v2/write", r.URL.Path)
+		}
+		q := r.URL.Query()
+		if q.Get("org") != "home" || q.Get("bucket") != "vue" || q.Get("precision") != "s" {
+			t.Errorf("query = %q, want org, bucket and precision", r.URL.RawQuery)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Token secret" {
+			t.Errorf("Authorization = %q, want the token", auth)
+		}
+		if failures > 0 {
+			failures--
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		got = append(got, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	c := &Client{URL: *u, Org: "home", Bucket: "vue", Token: "secret"}
+	p := c.Push(Options{MaxLines: 2, RetryPolicy: vmclient.RetryPolicy{Backoff: time.Millisecond}})
+	s := vmclient.Series{
+		Metric: vmclient.Metric{Name: "vue_kwh", Labels: map[string]string{"chan": "1"}},
+		Samples: []vmclient.Sample{
+			{Value: 1.5, Timestamp: time.Unix(60, 0)},
+			{Value: 2.5, Timestamp: time.Unix(120, 0)},
+			{Value: 3.5, Timestamp: time.Unix(180, 0)},
+		},
+	}
+	if err := p.Push(&s); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	want := []string{
+		"vue_kwh,chan=1 value=1.5 60\nvue_kwh,chan=1 value=2.5 120\n",
+		"vue_kwh,chan=1 value=3.5 180\n",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("writes diff (-want+got):\n%s", diff)
+	}
+	if got, want := p.Stats(), (vmclient.PushStats{Series: 2, Samples: 3}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPusher_Rejected(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "bad line", http.StatusBadRequest)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	p := (&Client{URL: *u}).Push(Options{RetryPolicy: vmclient.RetryPolicy{Backoff: time.Millisecond}})
+	p.Push(&vmclient.Series{Metric: vmclient.Metric{Name: "vue_kwh"}, Samples: []vmclient.Sample{{Value: 1}}})
+	err := p.Close()
+	var herr *vmclient.HTTPError
+	if !errors.As(err, &herr) || herr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Close() error = %v, want a 400 HTTPError", err)
+	}
+	if requests != 1 {
+		t.Errorf("requests = %d, want 1: client errors are not retried", requests)
+	}
+}
@@ -0,0 +1,49 @@
+// Package cursor records how far each series has been written to a destination
+// that cannot be queried for its latest sample.
+package cursor
+
+import (
+	"time"
+
+	"sgrankin.dev/vuescrape/internal/jsondb"
+)
+
+// File is a set of cursors persisted as JSON.
+type File struct {
+	db *jsondb.DB[map[string]time.Time]
+}
+
+// Open opens the cursor file at path, creating an empty one if necessary.
+func Open(path string) (*File, error) {
+	db, err := jsondb.OpenWith(path, jsondb.Options[map[string]time.Time]{Version: 1})
+	if err != nil {
+		return nil, err
+	}
+	if *db.Data == nil {
+		*db.Data = map[string]time.Time{}
+	}
+	return &File{db}, nil
+}
+
+// Last returns the timestamp of the last sample written for the series, or the zero time if none was.
+func (f *File) Last(series string) time.Time {
+	return (*f.db.Data)[series]
+}
+
+// Advance records ts as the last sample written for the series and saves the file.
+// Timestamps older than the current cursor are ignored.
+// Cursors advanced by other processes sharing the file are kept.
+func (f *File) Advance(series string, ts time.Time) error {
+	if !ts.After(f.Last(series)) {
+		return nil
+	}
+	return f.db.Update(func(data *map[string]time.Time) error {
+		if *data == nil {
+			*data = map[string]time.Time{}
+		}
+		if ts.After((*data)[series]) {
+			(*data)[series] = ts.UTC()
+		}
+		return nil
+	})
+}
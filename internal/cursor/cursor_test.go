@@ -0,0 +1,49 @@
+package cursor
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFile_Advance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cursors.json")
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := f.Advance("power", ts); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Advance("power", ts.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.Last("power"); !got.Equal(ts) {
+		t.Errorf("Last() after advancing backwards = %v, want %v", got, ts)
+	}
+
+	// Cursors are saved, and those advanced through another handle on the file are kept.
+	other, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := other.Last("power"); !got.Equal(ts) {
+		t.Errorf("reopened Last() = %v, want %v", got, ts)
+	}
+	if err := other.Advance("energy", ts); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Advance("power", ts.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for series, want := range map[string]time.Time{"power": ts.Add(time.Minute), "energy": ts} {
+		if got := reopened.Last(series); !got.Equal(want) {
+			t.Errorf("Last(%q) = %v, want %v", series, got, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// Package flock provides advisory file locks, to coordinate processes that share files.
+//
+// Locks are only advisory: they exclude other holders of the same lock, not other readers or writers of files.
+// Where the platform has no flock, locking always succeeds, with a warning logged the first time.
+package flock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked is returned by [TryLock] if another process holds the lock.
+var ErrLocked = errors.New("flock: locked by another process")
+
+// Lock is a held lock.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire takes the exclusive lock at path, waiting for other holders to release it.
+// The lock file is created if needed.
+func Acquire(path string) (*Lock, error) {
+	return acquire(path, true)
+}
+
+// TryLock takes the exclusive lock at path, returning [ErrLocked] if another process holds it.
+func TryLock(path string) (*Lock, error) {
+	return acquire(path, false)
+}
+
+func acquire(path string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lock(f, wait); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &Lock{f}, nil
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	// Closing the file drops the lock.
+	return l.f.Close()
+}
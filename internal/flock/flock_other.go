@@ -0,0 +1,19 @@
+//go:build !unix
+
+package flock
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"sync"
+)
+
+var warnOnce sync.Once
+
+func lock(*os.File, bool) error {
+	warnOnce.Do(func() {
+		log.Printf("warning: file locks are not supported on %s; processes sharing files may undo each other's changes", runtime.GOOS)
+	})
+	return nil
+}
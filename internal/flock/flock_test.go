@@ -0,0 +1,26 @@
+package flock
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.lock")
+	l, err := Acquire(path)
+	if err != nil {
+		t.Fatalf("Acquire() error = %v", err)
+	}
+	if _, err := TryLock(path); !errors.Is(err, ErrLocked) {
+		t.Errorf("TryLock() while held error = %v, want %v", err, ErrLocked)
+	}
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	l, err = TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock() after release error = %v", err)
+	}
+	l.Release()
+}
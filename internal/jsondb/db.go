@@ -3,15 +3,22 @@
 
 // Package jsondb provides a trivial "database": a Go object saved to
 // disk as JSON.
+//
+// Databases opened with a [Options.Version] are saved in an envelope
+// recording the version of their format, and older files are
+// migrated when opened.
 package jsondb
 
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 
 	"sgrankin.dev/vuescrape/internal/atomicfile"
+	"sgrankin.dev/vuescrape/internal/flock"
+	"sgrankin.dev/vuescrape/internal/seal"
 )
 
 // DB is a database backed by a JSON file.
@@ -20,38 +27,203 @@ type DB[T any] struct {
 	Data *T
 
 	path string
+	opts Options[T]
+}
+
+// Options configure how a database is stored.
+type Options[T any] struct {
+	// Sealer, if set, encrypts the file at rest.  A file that was
+	// saved unencrypted is encrypted when opened.
+	Sealer *seal.Sealer
+
+	// Version is the current version of the format of the data.
+	// If zero, the data is saved as bare JSON, without a version.
+	Version int
+	// Migrations upgrade data from the version they are keyed by to
+	// the next one.  Version 0 is bare JSON, as saved without a
+	// Version.  Without a migration, data is kept as it is.
+	Migrations map[int]func(json.RawMessage) (json.RawMessage, error)
+	// Validate, if set, checks the data after it is loaded.
+	Validate func(*T) error
+}
+
+// envelope is the saved form of versioned data.
+type envelope struct {
+	Version int             `json:"jsondb_version"`
+	Data    json.RawMessage `json:"data"`
 }
 
 // Open opens the database at path, creating it with a zero value if
 // necessary.
 func Open[T any](path string) (*DB[T], error) {
-	bs, err := os.ReadFile(path)
+	return OpenWith(path, Options[T]{})
+}
+
+// OpenWith opens the database at path like [Open], migrating it to the
+// current version.  Before a migrated database is saved, the previous
+// file is copied to path.vN.bak, where N is its version.
+func OpenWith[T any](path string, opts Options[T]) (*DB[T], error) {
+	db := &DB[T]{
+		path: path,
+		opts: opts,
+	}
+	f, err := db.load()
+	if err != nil {
+		return nil, err
+	}
+	if !db.outdated(f) {
+		return db, nil
+	}
+
+	l, err := db.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	// Another process may have upgraded, and since changed, the file
+	// before the lock was taken; saving what was read above would
+	// undo that.
+	if f, err = db.load(); err != nil {
+		return nil, err
+	}
+	if !db.outdated(f) {
+		return db, nil
+	}
+	if f.version != opts.Version {
+		if err := db.backup(f); err != nil {
+			return nil, fmt.Errorf("backing up %s: %w", path, err)
+		}
+	}
+	if err := db.save(); err != nil {
+		return nil, fmt.Errorf("upgrading %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// outdated reports whether a loaded file needs to be saved in the
+// current format.
+func (db *DB[T]) outdated(f *file) bool {
+	return f != nil && (f.version != db.opts.Version || (db.opts.Sealer != nil && !f.sealed))
+}
+
+// file is the state of a loaded file.
+type file struct {
+	plaintext []byte // As read, after decryption.
+	sealed    bool
+	version   int
+}
+
+// load reads the file into db.Data, or a zero value if it doesn't
+// exist, in which case the returned file is nil.
+func (db *DB[T]) load() (*file, error) {
+	bs, err := os.ReadFile(db.path)
 	if errors.Is(err, fs.ErrNotExist) {
-		return &DB[T]{
-			Data: new(T),
-			path: path,
-		}, nil
+		db.Data = new(T)
+		return nil, nil
 	} else if err != nil {
 		return nil, err
 	}
 
+	f := &file{plaintext: bs, sealed: seal.IsSealed(bs)}
+	if f.sealed {
+		if db.opts.Sealer == nil {
+			return nil, fmt.Errorf("%s is encrypted, but no passphrase was given", db.path)
+		}
+		if f.plaintext, err = db.opts.Sealer.Open(bs); err != nil {
+			return nil, fmt.Errorf("%s: %w", db.path, err)
+		}
+	}
+
+	data := json.RawMessage(f.plaintext)
+	var env envelope
+	if json.Unmarshal(f.plaintext, &env) == nil && env.Version > 0 {
+		f.version, data = env.Version, env.Data
+	}
+	if f.version > db.opts.Version {
+		return nil, fmt.Errorf("%s has version %d, newer than the supported %d", db.path, f.version, db.opts.Version)
+	}
+	for v := f.version; v < db.opts.Version; v++ {
+		if m := db.opts.Migrations[v]; m != nil {
+			if data, err = m(data); err != nil {
+				return nil, fmt.Errorf("%s: migrating from version %d: %w", db.path, v, err)
+			}
+		}
+	}
+
 	var val T
-	if err := json.Unmarshal(bs, &val); err != nil {
-		return nil, err
+	if err := json.Unmarshal(data, &val); err != nil {
+		return nil, fmt.Errorf("%s: %w", db.path, err)
+	}
+	if db.opts.Validate != nil {
+		if err := db.opts.Validate(&val); err != nil {
+			return nil, fmt.Errorf("%s: invalid data: %w", db.path, err)
+		}
 	}
+	db.Data = &val
+	return f, nil
+}
 
-	return &DB[T]{
-		Data: &val,
-		path: path,
-	}, nil
+// backup saves a copy of a loaded file, encrypted if the database is.
+func (db *DB[T]) backup(f *file) error {
+	bs := f.plaintext
+	if db.opts.Sealer != nil {
+		var err error
+		if bs, err = db.opts.Sealer.Seal(bs); err != nil {
+			return err
+		}
+	}
+	return atomicfile.WriteFile(fmt.Sprintf("%s.v%d.bak", db.path, f.version), bs, 0600)
+}
+
+// lock takes the advisory lock that other processes using the
+// database also take before writing it.
+func (db *DB[T]) lock() (*flock.Lock, error) {
+	return flock.Acquire(db.path + ".lock")
 }
 
 // Save writes db.Data back to disk.
 func (db *DB[T]) Save() error {
+	l, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	return db.save()
+}
+
+func (db *DB[T]) save() error {
 	bs, err := json.Marshal(db.Data)
 	if err != nil {
 		return err
 	}
+	if db.opts.Version > 0 {
+		if bs, err = json.Marshal(&envelope{Version: db.opts.Version, Data: bs}); err != nil {
+			return err
+		}
+	}
+	if db.opts.Sealer != nil {
+		if bs, err = db.opts.Sealer.Seal(bs); err != nil {
+			return err
+		}
+	}
 
 	return atomicfile.WriteFile(db.path, bs, 0600)
 }
+
+// Update reloads db.Data from disk, calls f to modify it and, if f
+// returns nil, saves it, all while holding the lock, so that changes
+// made by other processes in between are neither lost nor overwritten.
+func (db *DB[T]) Update(f func(*T) error) error {
+	l, err := db.lock()
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	if _, err := db.load(); err != nil {
+		return err
+	}
+	if err := f(db.Data); err != nil {
+		return err
+	}
+	return db.save()
+}
@@ -0,0 +1,203 @@
+package jsondb
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"sgrankin.dev/vuescrape/internal/seal"
+)
+
+type token struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func TestOpenWith_Seals(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(`{"refresh_token":"secret"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenWith(path, Options[token]{Sealer: seal.New([]byte("passphrase"))})
+	if err != nil {
+		t.Fatalf("OpenWith() error = %v", err)
+	}
+	if db.Data.RefreshToken != "secret" {
+		t.Errorf("OpenWith() data = %+v, want the plaintext file's", db.Data)
+	}
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !seal.IsSealed(bs) {
+		t.Errorf("file after OpenWith() = %s, want it encrypted", bs)
+	}
+
+	if _, err := Open[token](path); err == nil {
+		t.Errorf("Open() of an encrypted file succeeded, want an error")
+	}
+	db, err = OpenWith(path, Options[token]{Sealer: seal.New([]byte("passphrase"))})
+	if err != nil {
+		t.Fatalf("OpenWith() again error = %v", err)
+	}
+	if db.Data.RefreshToken != "secret" {
+		t.Errorf("OpenWith() again data = %+v, want the saved data", db.Data)
+	}
+}
+
+func TestUpdate_Rereads(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	db1, err := Open[token](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db2, err := Open[token](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db1.Data.RefreshToken = "new"
+	if err := db1.Save(); err != nil {
+		t.Fatal(err)
+	}
+	var seen string
+	err = db2.Update(func(tok *token) error {
+		seen = tok.RefreshToken
+		tok.RefreshToken += "er"
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if seen != "new" {
+		t.Errorf("Update() saw %q, want the other handle's save", seen)
+	}
+	db3, err := Open[token](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db3.Data.RefreshToken != "newer" {
+		t.Errorf("after Update() file has %q, want %q", db3.Data.RefreshToken, "newer")
+	}
+}
+
+func TestOpenWith_Migrates(t *testing.T) {
+	type tokenV2 struct {
+		Refresh string `json:"refresh"`
+	}
+	opts := Options[tokenV2]{
+		Version: 2,
+		Migrations: map[int]func(json.RawMessage) (json.RawMessage, error){
+			// Version 1 only added the envelope.
+			1: func(data json.RawMessage) (json.RawMessage, error) {
+				var old token
+				if err := json.Unmarshal(data, &old); err != nil {
+					return nil, err
+				}
+				return json.Marshal(&tokenV2{Refresh: old.RefreshToken})
+			},
+		},
+		Validate: func(tok *tokenV2) error {
+			if tok.Refresh == "" {
+				return errors.New("no refresh token")
+			}
+			return nil
+		},
+	}
+	for _, tt := range []struct {
+		name        string
+		file        string
+		wantErr     bool
+		wantBackup  string
+		wantRefresh string
+	}{
+		{"bare", `{"refresh_token":"secret"}`, false, "auth.json.v0.bak", "secret"},
+		{"version 1", `{"jsondb_version":1,"data":{"refresh_token":"secret"}}`, false, "auth.json.v1.bak", "secret"},
+		{"current", `{"jsondb_version":2,"data":{"refresh":"secret"}}`, false, "", "secret"},
+		{"newer", `{"jsondb_version":3,"data":{}}`, true, "", ""},
+		{"invalid", `{"refresh_token":""}`, true, "", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "auth.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			db, err := OpenWith(path, opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OpenWith() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if db.Data.Refresh != tt.wantRefresh {
+				t.Errorf("OpenWith() data = %+v, want refresh %q", db.Data, tt.wantRefresh)
+			}
+			if tt.wantBackup != "" {
+				bs, err := os.ReadFile(filepath.Join(dir, tt.wantBackup))
+				if err != nil || string(bs) != tt.file {
+					t.Errorf("backup = %s, %v, want the original file", bs, err)
+				}
+			}
+			bs, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := `{"jsondb_version":2,"data":{"refresh":"secret"}}`; string(bs) != want {
+				t.Errorf("file after OpenWith() = %s, want %s", bs, want)
+			}
+		})
+	}
+}
+
+func TestOpenWith_ConcurrentUpgrade(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cursors.json")
+	if err := os.WriteFile(path, []byte(`{"old":1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each process upgrades the file and then adds to it.  An upgrade
+	// racing with another's update must not undo that update.
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db, err := OpenWith(path, Options[map[string]int]{Version: 1})
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- db.Update(func(m *map[string]int) error {
+				(*m)[strconv.Itoa(i)] = i
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := OpenWith(path, Options[map[string]int]{Version: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*db.Data) != n+1 {
+		t.Errorf("data after concurrent upgrades = %v, want the old key and %d new ones", *db.Data, n)
+	}
+	bs, err := os.ReadFile(path + ".v0.bak")
+	if err != nil || string(bs) != `{"old":1}` {
+		t.Errorf("backup = %s, %v, want the original file", bs, err)
+	}
+}
@@ -0,0 +1,37 @@
+package seal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealer(t *testing.T) {
+	plaintext := []byte(`{"refresh_token":"secret"}`)
+	sealed, err := New([]byte("passphrase")).Seal(plaintext)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	if !IsSealed(sealed) || IsSealed(plaintext) {
+		t.Errorf("IsSealed() = %v for sealed data, %v for plaintext", IsSealed(sealed), IsSealed(plaintext))
+	}
+	if bytes.Contains(sealed, []byte("secret")) {
+		t.Errorf("Seal() = %s, contains the plaintext", sealed)
+	}
+
+	got, err := New([]byte("passphrase")).Open(sealed)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Errorf("Open() = %s, want %s", got, plaintext)
+	}
+
+	if _, err := New([]byte("wrong")).Open(sealed); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Open() with the wrong passphrase error = %v, want %v", err, ErrWrongKey)
+	}
+	tampered := bytes.Replace(sealed, []byte(`"data":"`), []byte(`"data":"AAAA`), 1)
+	if _, err := New([]byte("passphrase")).Open(tampered); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Open() of tampered data error = %v, want %v", err, ErrWrongKey)
+	}
+}
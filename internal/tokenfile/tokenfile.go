@@ -0,0 +1,70 @@
+// Package tokenfile stores an Emporia token in a file shared by vuescrape processes.
+package tokenfile
+
+import (
+	"errors"
+
+	"sgrankin.dev/vuescrape/internal/jsondb"
+	"sgrankin.dev/vuescrape/internal/seal"
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// version is the current format of token files.
+//
+//   - 0: a bare [vueclient.Token], as saved by releases before versioning.
+//   - 1: the same token in a versioned envelope.
+//
+// Releases that only know format 0 decode an envelope as a token
+// with every field empty, without an error, so they sign in again
+// and overwrite the file with format 0, which Open upgrades again.
+// Nothing is lost but the sign-in, so token files are versioned
+// regardless.
+const version = 1
+
+// Store is a token file.
+type Store struct {
+	db *jsondb.DB[vueclient.Token]
+}
+
+// Open opens the token file at path, upgrading it to the current format.
+// If sealer is set, the token is stored encrypted.
+func Open(path string, sealer *seal.Sealer) (*Store, error) {
+	db, err := jsondb.OpenWith(path, jsondb.Options[vueclient.Token]{
+		Sealer:   sealer,
+		Version:  version,
+		Validate: validate,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db}, nil
+}
+
+func validate(tok *vueclient.Token) error {
+	if tok.AccessToken != "" && tok.Expiry.IsZero() {
+		// Such a token would never be renewed.
+		return errors.New("token has no expiry")
+	}
+	return nil
+}
+
+// Token returns the token as it was when the file was opened.
+func (s *Store) Token() *vueclient.Token {
+	return s.db.Data
+}
+
+// Update implements [vueclient.TokenStore].
+func (s *Store) Update(f func(*vueclient.Token) (*vueclient.Token, error)) (*vueclient.Token, error) {
+	var tok *vueclient.Token
+	err := s.db.Update(func(stored *vueclient.Token) error {
+		var err error
+		if tok, err = f(stored); err != nil {
+			return err
+		}
+		*stored = *tok
+		return nil
+	})
+	return tok, err
+}
+
+var _ vueclient.TokenStore = (*Store)(nil)
@@ -0,0 +1,85 @@
+package tokenfile
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sgrankin.dev/vuescrape/internal/seal"
+)
+
+// oldAuth is an auth.json as saved before token files were versioned.
+const oldAuth = `{"access_token":"access","token_type":"Bearer","refresh_token":"refresh","expiry":"2024-03-01T13:00:00Z","id_token":"id"}`
+
+func TestOpen_Upgrades(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		sealer *seal.Sealer
+	}{
+		{"plaintext", nil},
+		{"sealed", seal.New([]byte("passphrase"))},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "auth.json")
+			if err := os.WriteFile(path, []byte(oldAuth), 0600); err != nil {
+				t.Fatal(err)
+			}
+			s, err := Open(path, tt.sealer)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			tok := s.Token()
+			if tok.AccessToken != "access" || tok.RefreshToken != "refresh" || tok.IDToken != "id" ||
+				!tok.Expiry.Equal(time.Date(2024, 3, 1, 13, 0, 0, 0, time.UTC)) {
+				t.Errorf("Open() token = %+v, want the old file's", tok)
+			}
+
+			bs, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			backup, err := os.ReadFile(path + ".v0.bak")
+			if err != nil {
+				t.Fatalf("no backup: %v", err)
+			}
+			if tt.sealer != nil {
+				if !seal.IsSealed(bs) || !seal.IsSealed(backup) {
+					t.Fatalf("file and backup after Open() = %s and %s, want both encrypted", bs, backup)
+				}
+				if bs, err = tt.sealer.Open(bs); err != nil {
+					t.Fatal(err)
+				}
+				if backup, err = tt.sealer.Open(backup); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if string(backup) != oldAuth {
+				t.Errorf("backup = %s, want the old file", backup)
+			}
+			var env struct {
+				Version int `json:"jsondb_version"`
+			}
+			if err := json.Unmarshal(bs, &env); err != nil || env.Version != version {
+				t.Errorf("file after Open() = %s, want version %d", bs, version)
+			}
+
+			// The upgraded file opens as is.
+			if _, err := Open(path, tt.sealer); err != nil {
+				t.Fatalf("Open() of the upgraded file error = %v", err)
+			}
+		})
+	}
+}
+
+func TestOpen_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := os.WriteFile(path, []byte(`{"access_token":"access"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, nil); err == nil {
+		t.Errorf("Open() of a token without expiry succeeded, want an error")
+	}
+}
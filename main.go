@@ -1,160 +1,670 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"maps"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 	"github.com/charmbracelet/huh"
+	"golang.org/x/term"
+	"golang.org/x/time/rate"
 
-	"sgrankin.dev/vuescrape/internal/jsondb"
+	"sgrankin.dev/vuescrape/internal/flock"
+	"sgrankin.dev/vuescrape/internal/seal"
+	"sgrankin.dev/vuescrape/internal/tokenfile"
 	"sgrankin.dev/vuescrape/vmclient"
 	"sgrankin.dev/vuescrape/vueclient"
 )
 
 func init() {
 	log.SetFlags(log.LstdFlags | log.Lmicroseconds | log.Lshortfile)
+	flag.Var(destHeader, "dest-header",
+		"Extra `header` sent to the destination, as \"Name: value\".  May be repeated.")
+	flag.Var(remoteWriteHeader, "remote-write-header",
+		"Extra `header` sent to -remote-write-url and -remote-write-query-url, as \"Name: value\".  May be repeated.")
 }
 
 var (
 	dest = flag.String("dest", "",
-		"Destination host:port of VictoriaMetrics.")
-	lookback = flag.Duration("lookback", 10*24*time.Hour,
+		"Destination host:port of VictoriaMetrics.  May be a comma-separated list to write to several servers with the same settings.")
+	destInsert = flag.String("dest-insert", "",
+		"Host:port of vminsert, if different from -dest.")
+	destSelect = flag.String("dest-select", "",
+		"Host:port of vmselect, if different from -dest.")
+	destTenant = flag.String("dest-tenant", "",
+		"VictoriaMetrics cluster tenant (`accountID[:projectID]`).  Enables cluster URL paths.")
+	destScheme = flag.String("dest-scheme", "http",
+		"URL scheme used to reach the destination: http or https.")
+	destUsername = flag.String("dest-username", "",
+		"Username for basic auth to the destination.")
+	destPassword = flag.String("dest-password", "",
+		"Password for basic auth to the destination.")
+	destBearerToken = flag.String("dest-bearer-token", "",
+		"Bearer token for the destination, e.g. for vmauth.  Takes precedence over basic auth.")
+	destCA = flag.String("dest-ca", "",
+		"PEM file of CAs used to verify the destination's certificate.")
+	destCert = flag.String("dest-cert", "",
+		"PEM client certificate presented to the destination.")
+	destKey = flag.String("dest-key", "",
+		"PEM key for -dest-cert.")
+	destInsecure = flag.Bool("dest-insecure-skip-verify", false,
+		"Do not verify the destination's certificate.")
+	destHeader = headerFlag{}
+	lookback   = flag.Duration("lookback", 10*24*time.Hour,
 		"Look this far back for measurements to catch up to what's in destination.")
 	username = flag.String("username", "",
 		"Emporia Vue username for initial auth.  Will be prompted if flag is not passed.")
 	password = flag.String("passwod", "",
 		"Emporia Vue passwod for initial auth.  Will be prompted if flag is not passed.")
+	accounts = flag.String("accounts", "",
+		"Comma-separated `names` of Emporia accounts to export, each with its own stored token.  Their series get an account label.  If empty, a single unlabeled account is used.  A name may be followed by @accountID[:projectID] to write the account to its own VictoriaMetrics cluster tenant instead of -dest-tenant.")
+	tokenKeyFile = flag.String("token-key-file", "",
+		"File holding a passphrase to encrypt stored Emporia tokens with.  The passphrase may instead be set with $VUESCRAPE_TOKEN_KEY or -token-passphrase.")
+	tokenPassphrase = flag.Bool("token-passphrase", false,
+		"Prompt for a passphrase to encrypt stored Emporia tokens with.")
+	exclusive = flag.String("exclusive", "",
+		"What to do if another vuescrape run is in progress: skip this run, or wait for the other to finish.  If empty, runs overlap.")
+	interval = flag.Duration("interval", 0,
+		"If set, keep running, exporting new samples at this interval.")
+	tokenRefreshMargin = flag.Duration("token-refresh-margin", 5*time.Minute,
+		"Renew Emporia tokens this long before they expire, in the background.  With -interval, tokens are kept fresh between runs too.")
+	rateLimit = flag.Float64("rate", float64(vueclient.DefaultRate),
+		"Maximum Emporia API requests per second, per account.")
+	adaptiveRate = flag.Bool("adaptive-rate", false,
+		"Slow down when Emporia responds with 429 Too Many Requests or slowly, recovering gradually up to -rate.")
+	dailyBudget = flag.Int("daily-budget", 0,
+		"If positive, the most Emporia API requests to make per account per day, counted across runs.  History beyond it is fetched by later runs.")
+	sinkType = flag.String("sink", "vm",
+		"Destination `types` for samples, comma-separated: vm (VictoriaMetrics JSON import), remote-write (Prometheus remote write to -remote-write-url), or influx (InfluxDB line protocol).")
+	remoteWriteURL = flag.String("remote-write-url", "",
+		"Prometheus remote write endpoint, e.g. http://prometheus:9090/api/v1/write.")
+	remoteWriteQueryURL = flag.String("remote-write-query-url", "",
+		"Base URL of a Prometheus compatible query API that reads what -remote-write-url writes, e.g. http://prometheus:9090, used to find the last written samples.  If empty, they are tracked in -remote-write-cursor-file.")
+	remoteWriteCursorFile = flag.String("remote-write-cursor-file", "",
+		"File recording the last sample written to -remote-write-url for each series, if there is no -remote-write-query-url.  Defaults to a file in the user config directory.")
+	remoteWriteUsername = flag.String("remote-write-username", "",
+		"Basic auth username for -remote-write-url and -remote-write-query-url.")
+	remoteWritePassword = flag.String("remote-write-password", "",
+		"Basic auth password for -remote-write-url and -remote-write-query-url.")
+	remoteWriteBearerToken = flag.String("remote-write-bearer-token", "",
+		"Bearer token for -remote-write-url and -remote-write-query-url.  Takes precedence over basic auth.")
+	remoteWriteCA = flag.String("remote-write-ca", "",
+		"PEM file of CAs used to verify the certificates of -remote-write-url and -remote-write-query-url.")
+	remoteWriteCert = flag.String("remote-write-cert", "",
+		"PEM client certificate presented to -remote-write-url and -remote-write-query-url.")
+	remoteWriteKey = flag.String("remote-write-key", "",
+		"PEM key for -remote-write-cert.")
+	remoteWriteInsecure = flag.Bool("remote-write-insecure-skip-verify", false,
+		"Do not verify the certificates of -remote-write-url and -remote-write-query-url.")
+	remoteWriteHeader = headerFlag{}
+	influxURL         = flag.String("influx-url", "",
+		"InfluxDB 2 compatible server URL, e.g. http://influxdb:8086.")
+	influxOrg = flag.String("influx-org", "",
+		"InfluxDB organization.")
+	influxBucket = flag.String("influx-bucket", "",
+		"InfluxDB bucket.")
+	influxToken = flag.String("influx-token", "",
+		"InfluxDB API token.")
+	influxCA = flag.String("influx-ca", "",
+		"PEM file of CAs used to verify the certificate of -influx-url.")
+	influxCert = flag.String("influx-cert", "",
+		"PEM client certificate presented to -influx-url.")
+	influxKey = flag.String("influx-key", "",
+		"PEM key for -influx-cert.")
+	influxInsecure = flag.Bool("influx-insecure-skip-verify", false,
+		"Do not verify the certificate of -influx-url.")
+	influxFile = flag.String("influx-file", "",
+		"Append line protocol to this file instead of sending it to -influx-url.")
+	influxPrecision = flag.String("influx-precision", "s",
+		"Timestamp precision for line protocol: ns, us, ms or s.")
+	cursorFile = flag.String("cursor-file", "",
+		"File recording the last sample written for each series to -sink=influx, which cannot be queried.  Defaults to a file in the user config directory.")
+	vueAPI = flag.String("vue-api", "",
+		"Base URL of the Emporia API.  Defaults to the real one; see the fake-server command.")
+	cognitoEndpoint = flag.String("cognito-endpoint", "",
+		"URL of the Cognito API used to sign in.  Defaults to the real one; see the fake-server command.")
+	archiveDir = flag.String("archive-dir", "",
+		"Directory to archive raw Emporia API responses in, one gzip JSON lines file per run.  See the replay command.")
+	spoolDir = flag.String("spool-dir", "",
+		"Directory to queue imports that VictoriaMetrics did not accept.  Queued imports are retried on the next run.  With several -dest, each gets a subdirectory.")
 )
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
-	if err := run(*dest, *lookback, *username, *password); err != nil {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch cmd := flag.Arg(0); cmd {
+	case "":
+		err = exclusively(configDir, func() error { return runScrape(configDir) })
+	case "export":
+		err = exclusively(configDir, func() error { return runExport(configDir, flag.Args()[1:]) })
+	case "replay":
+		err = exclusively(configDir, func() error { return runReplay(configDir, flag.Args()[1:]) })
+	case "outlet":
+		err = runOutlet(configDir, flag.Args()[1:])
+	case "evcharger":
+		err = runEVCharger(configDir, flag.Args()[1:])
+	case "fake-server":
+		err = runFakeServer(flag.Args()[1:])
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run(dest string, lookback time.Duration, username, password string) error {
-	configDir, err := os.UserConfigDir()
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [command [command flags]]
+
+Without a command, exports new samples from Emporia Vue to the destinations.
+
+Commands:
+  export       write channel history to CSV or Parquet files
+  replay       write the history in -archive-dir files to the destinations
+  outlet       list smart plugs and whether they are on, or turn one on or off
+  evcharger    list EV chargers and their state, or pause or resume charging
+  fake-server  serve a fake Emporia API with synthetic data, for use with -vue-api and -cognito-endpoint
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
+// exclusively calls f unless another run holds the run lock, as selected by -exclusive.
+// The lock is held until f returns, which with -interval is never.
+func exclusively(configDir string, f func() error) error {
+	path := filepath.Join(configDir, "vuescrape", "run.lock")
+	var l *flock.Lock
+	var err error
+	switch *exclusive {
+	case "":
+		return f()
+	case "skip":
+		l, err = flock.TryLock(path)
+		if errors.Is(err, flock.ErrLocked) {
+			log.Printf("another run is in progress; skipping this one")
+			return nil
+		}
+	case "wait":
+		l, err = flock.Acquire(path)
+	default:
+		return fmt.Errorf("unknown -exclusive %q", *exclusive)
+	}
 	if err != nil {
 		return err
 	}
+	defer l.Release()
+	return f()
+}
+
+// runScrape implements the default command, exporting new samples once or, with -interval, repeatedly.
+func runScrape(configDir string) (err error) {
+	dsts, err := newDestinations(configDir)
+	if err != nil {
+		return err
+	}
+	accts, err := newAccounts(configDir)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeAccounts(accts)) }()
+	byTenant := destinationsByTenant(dsts, accts)
+	if *interval <= 0 {
+		return run(accts, byTenant, *lookback)
+	}
+
+	// Stop between runs on SIGINT or SIGTERM, so that tokens are saved and archives closed.
+	// A second signal, during a run, stops at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	for _, a := range accts {
+		go a.vue.Tokens.KeepFresh(ctx)
+	}
+	for {
+		if err := run(accts, byTenant, *lookback); err != nil {
+			log.Printf("run failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("stopping")
+			return nil
+		case <-time.After(*interval):
+		}
+	}
+}
+
+// destinationsByTenant returns the destinations for each tenant of the accounts, keyed by tenant.
+// Accounts without a tenant of their own, under "", use dsts.
+func destinationsByTenant(dsts []destination, accts []*account) map[string][]destination {
+	byTenant := map[string][]destination{"": dsts}
+	for _, a := range accts {
+		if _, ok := byTenant[a.tenant]; !ok {
+			byTenant[a.tenant] = withTenant(dsts, a.tenant)
+		}
+	}
+	return byTenant
+}
+
+// run exports new samples of every account to the destinations of its tenant.
+// Keep going after failures so that one bad account, channel or destination doesn't hold up the rest.
+func run(accts []*account, byTenant map[string][]destination, lookback time.Duration) error {
+	// Every run retries what earlier ones spooled, including earlier runs of this process with -interval.
+	healthy := map[string][]destination{}
+	for tenant, dsts := range byTenant {
+		healthy[tenant] = replaySpools(dsts)
+	}
+	var errs []error
+	for _, a := range accts {
+		dsts := healthy[a.tenant]
+		errs = append(errs, a.wrap(runAccount(a, dsts, lookback)))
+		errs = append(errs, a.wrap(writeTokenMetrics(dsts, a, time.Now())))
+	}
+	return errors.Join(errs...)
+}
 
-	vm := &vmclient.Client{Dest: url.URL{Scheme: "http", Host: dest}}
-	tokDB, err := jsondb.Open[vueclient.Token](filepath.Join(configDir, "vuescrape", "auth.json"))
+func runAccount(a *account, dsts []destination, lookback time.Duration) (err error) {
+	if a.budget != nil {
+		done, startErr := a.budget.start(a.vue, time.Now())
+		if startErr != nil {
+			return startErr
+		}
+		defer func() { err = errors.Join(err, done()) }()
+		if n, _ := a.vue.Throttle.Remaining(); n == 0 {
+			log.Printf("%s", a.wrap(errors.New("daily request budget spent; deferring to a later run")))
+			return nil
+		}
+	}
+	devs, err := a.vue.GetDevices()
 	if err != nil {
+		return deferSpent(a, err)
+	}
+	until := time.Now()
+	since := until.Add(-lookback)
+	scale := vueclient.Scale1Minute
+	errs := []error{deferSpent(a, writeDeviceStatus(dsts, a, devs, until))}
+	for _, ch := range channels(devs) {
+		errs = append(errs, exportHistory(dsts, a, ch, since, until, scale))
+	}
+	return errors.Join(errs...)
+}
+
+// deferSpent drops err if it is because the daily request budget ran out, logging that the rest is deferred.
+// exportHistory keeps within the budget, but other requests, such as those for device status, may use it up first.
+func deferSpent(a *account, err error) error {
+	if !errors.Is(err, vueclient.ErrBudgetSpent) {
 		return err
 	}
-	tok := vueclient.NewAtom(tokDB.Data)
-	tok.Watch(func(t1, t2 *vueclient.Token) {
-		tokDB.Data = t2
-		if err := tokDB.Save(); err != nil {
-			log.Fatalf("could not save new token: %v", err)
+	log.Printf("%s", a.wrap(fmt.Errorf("%w; deferring to a later run", err)))
+	return nil
+}
+
+// newVueClient creates an Emporia client using the token stored in configDir for the named account.
+// If the token can't be refreshed, the username and password are used, prompting for them if empty.
+// If sealer is set, the token is stored encrypted.
+func newVueClient(configDir, account, username, password string, sealer *seal.Sealer) (*vueclient.Client, error) {
+	store, err := tokenfile.Open(filepath.Join(accountDir(configDir, account), "auth.json"), sealer)
+	if err != nil {
+		return nil, err
+	}
+	opts := vueclient.Options{
+		CognitoEndpoint: *cognitoEndpoint,
+		UserAgent:       "vuescrape",
+		Rate:            rate.Limit(*rateLimit),
+	}
+	if *vueAPI != "" {
+		opts.BaseURL, err = url.Parse(*vueAPI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -vue-api: %w", err)
 		}
-	})
-	vue := vueclient.NewClient(tok, func() (string, string, error) {
+	}
+	tok := vueclient.NewAtom(store.Token())
+	vue := vueclient.NewClientWith(tok, func() (string, string, error) {
 		if username != "" && password != "" {
 			return username, password, nil
 		}
+		if err := canPrompt(); err != nil {
+			return "", "", err
+		}
+		title := "username"
+		if account != "" {
+			title = fmt.Sprintf("username for account %s", account)
+		}
 		err := huh.NewForm(huh.NewGroup(
-			huh.NewInput().Title("username").Value(&username),
+			huh.NewInput().Title(title).Value(&username),
 			huh.NewInput().Title("password").Password(true).Value(&password))).Run()
 		return username, password, err
-	})
-	devs, err := vue.GetDevices()
-	if err != nil {
-		return err
+	}, opts)
+	// Renewed tokens are saved by the store, which other vuescrape processes share.
+	vue.Tokens.Store = store
+	vue.Tokens.RefreshMargin = *tokenRefreshMargin
+	vue.Throttle.Adaptive = *adaptiveRate
+	vue.Cognito.ChallengeFunc = answerChallenge
+	if *archiveDir != "" {
+		name := fmt.Sprintf("vue-%s.jsonl.gz", time.Now().UTC().Format("20060102T150405Z"))
+		if account != "" {
+			name = fmt.Sprintf("vue-%s-%s.jsonl.gz", account, time.Now().UTC().Format("20060102T150405Z"))
+		}
+		vue.Archive, err = vueclient.CreateArchive(filepath.Join(*archiveDir, name))
+		if err != nil {
+			return nil, err
+		}
 	}
-	until := time.Now()
-	since := until.Add(-lookback)
-	scale := vueclient.Scale1Minute
-	for _, dev := range devs {
-		for _, ch := range dev.Channels {
-			if err := exportHistory(vm, vue, ch, since, until, scale); err != nil {
-				return err
-			}
+	return vue, nil
+}
 
+// tokenSealer returns the sealer for stored tokens selected by flags, or nil if they are stored unencrypted.
+func tokenSealer() (*seal.Sealer, error) {
+	var passphrase string
+	switch {
+	case *tokenKeyFile != "":
+		bs, err := os.ReadFile(*tokenKeyFile)
+		if err != nil {
+			return nil, err
 		}
-		for _, subdev := range dev.Devices {
-			for _, ch := range subdev.Channels {
-				if err := exportHistory(vm, vue, ch, since, until, scale); err != nil {
-					return err
-				}
-			}
+		passphrase = strings.TrimSpace(string(bs))
+	case os.Getenv("VUESCRAPE_TOKEN_KEY") != "":
+		passphrase = os.Getenv("VUESCRAPE_TOKEN_KEY")
+	case *tokenPassphrase:
+		if err := canPrompt(); err != nil {
+			return nil, err
+		}
+		err := huh.NewForm(huh.NewGroup(
+			huh.NewInput().Title("token passphrase").Password(true).Value(&passphrase))).Run()
+		if err != nil {
+			return nil, err
 		}
+	default:
+		return nil, nil
+	}
+	if passphrase == "" {
+		return nil, errors.New("token passphrase is empty")
+	}
+	return seal.New([]byte(passphrase)), nil
+}
+
+// canPrompt returns an error if there is no terminal to prompt for sign in details on.
+func canPrompt() error {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("no terminal to prompt on; sign in interactively once to store a token")
 	}
 	return nil
 }
 
-// exportHistory will scrape the history for the given channel and push it to vm.
-func exportHistory(vm *vmclient.Client, vue *vueclient.Client, ch vueclient.Channel, since, until time.Time, scale vueclient.Scale) error {
-	// Find the last pushed change for this series so that we can advance `since`.
-	seriesName := fmt.Sprintf("vue_kwh{dev_gid=%q,chan=%q,scale=%q}", fmt.Sprint(ch.DeviceGID), ch.ChannelNum, scale)
-	existing, err := vm.Query(fmt.Sprintf("timestamp(%s[%s])", seriesName, until.Sub(since)))
-	if err != nil {
-		return err
+// answerChallenge prompts for the answer to a sign in challenge, such as an MFA code.
+// Without a terminal there is no one to ask, so it fails.
+func answerChallenge(c *vueclient.Challenge) (string, error) {
+	if err := canPrompt(); err != nil {
+		return "", err
+	}
+	title, password := string(c.Name), false
+	switch c.Name {
+	case types.ChallengeNameTypeSoftwareTokenMfa:
+		title = "authenticator app code"
+	case types.ChallengeNameTypeSmsMfa:
+		title = "code sent to " + c.Parameters["CODE_DELIVERY_DESTINATION"]
+	case types.ChallengeNameTypeNewPasswordRequired:
+		title, password = "new password", true
+	}
+	var answer string
+	err := huh.NewForm(huh.NewGroup(
+		huh.NewInput().Title(title).Password(password).Value(&answer))).Run()
+	return answer, err
+}
+
+// channels lists the channels of devs and their nested devices.
+func channels(devs []vueclient.Device) []vueclient.Channel {
+	var out []vueclient.Channel
+	for _, dev := range flattenDevices(devs) {
+		out = append(out, dev.Channels...)
 	}
-	if series, ok := existing.([]vmclient.Series); ok {
-		if len(series) == 1 && len(series[0].Samples) == 1 {
-			sample := series[0].Samples[0]
-			// We expect 1 series (the one we asked) or none if it's not yet created.
-			lastSample := time.Unix(int64(sample.Value), 0).
-				// Add a scale interval so that we only get new samples and avoid writing duplicates.
-				Add(scale.Duration())
-			if lastSample.After(since) {
-				since = lastSample
+	return out
+}
+
+// flattenDevices returns the devices and the devices nested in them.
+func flattenDevices(devs []vueclient.Device) []vueclient.Device {
+	var out []vueclient.Device
+	for _, dev := range devs {
+		out = append(out, dev)
+		out = append(out, dev.Devices...)
+	}
+	return out
+}
+
+// exportHistory will scrape the history for the given channel and write it to every destination.
+// History is fetched once, starting from where the destination furthest behind left off;
+// each destination only receives the samples it doesn't have yet.
+func exportHistory(dsts []destination, a *account, ch vueclient.Channel, since, until time.Time, scale vueclient.Scale) error {
+	seriesName, metric := channelSeries(a.name, ch, scale)
+	var errs []error
+	var active []destination
+	var starts []time.Time
+	fetchSince := until
+	for _, d := range dsts {
+		// Find the last pushed change for this series so that we can advance `since`.
+		last, err := d.Last(seriesName, until.Sub(since))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", d.name, seriesName, err))
+			continue
+		}
+		start := since
+		if !last.IsZero() {
+			// Add a scale interval so that we only get new samples and avoid writing duplicates.
+			if next := last.Add(scale.Duration()); next.After(start) {
+				start = next
+			}
+		}
+		active = append(active, d)
+		starts = append(starts, start)
+		if start.Before(fetchSince) {
+			fetchSince = start
+		}
+	}
+	if !fetchSince.Before(until) {
+		return errors.Join(errs...)
+	}
+	if n, limited := a.vue.Throttle.Remaining(); limited {
+		// Fetch what the budget covers; later runs continue from where the destinations leave off.
+		pages := int((until.Sub(fetchSince) + scale.PageSize() - 1) / scale.PageSize())
+		if pages > n {
+			if n == 0 {
+				log.Printf("%s: daily request budget spent; deferring to a later run", seriesName)
+				return errors.Join(errs...)
 			}
+			until = fetchSince.Add(time.Duration(n) * scale.PageSize())
+			log.Printf("%s: daily request budget covers history until %s; deferring the rest to a later run", seriesName, until.Format(time.RFC3339))
 		}
 	}
-	pusher, err := vm.Push()
+
+	start, found, err := a.vue.GetHistory(ch.DeviceGID, ch.ChannelNum, fetchSince, until, scale, vueclient.EnergyKWh)
+	if errors.Is(err, vueclient.ErrBudgetSpent) {
+		log.Printf("%s: %v; deferring to a later run", seriesName, err)
+		return errors.Join(errs...)
+	}
 	if err != nil {
-		return err
+		return errors.Join(append(errs, fmt.Errorf("%s: %w", seriesName, err))...)
+	}
+	samples := chartSamples(start, found, scale)
+	for i, d := range active {
+		// Samples are in time order, so skip the ones this destination already has.
+		first, _ := slices.BinarySearchFunc(samples, starts[i], func(s vmclient.Sample, t time.Time) int {
+			return s.Timestamp.Compare(t)
+		})
+		if err := writeSeries(d, seriesName, metric, samples[first:]); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w", d.name, seriesName, err))
+		}
 	}
-	defer pusher.Close()
-
-	name := ch.Name
-	if name == "" {
-		name = "__total__"
-	}
-	series := vmclient.Series{
-		Metric: vmclient.Metric{
-			Name: "vue_kwh",
-			Labels: map[string]string{
-				"dev_gid":   fmt.Sprint(ch.DeviceGID),
-				"chan":      ch.ChannelNum,
-				"name":      ch.Name,
-				"chan_mult": fmt.Sprint(ch.ChannelMultiplier),
-				"scale":     string(scale),
-			},
+	return errors.Join(errs...)
+}
+
+// channelSeries returns the selector and metric of the energy series for a channel of the named account.
+func channelSeries(account string, ch vueclient.Channel, scale vueclient.Scale) (string, vmclient.Metric) {
+	seriesName := fmt.Sprintf("vue_kwh{dev_gid=%q,chan=%q,scale=%q}", fmt.Sprint(ch.DeviceGID), ch.ChannelNum, scale)
+	metric := vmclient.Metric{
+		Name: "vue_kwh",
+		Labels: map[string]string{
+			"dev_gid":   fmt.Sprint(ch.DeviceGID),
+			"chan":      ch.ChannelNum,
+			"name":      ch.Name,
+			"chan_mult": fmt.Sprint(ch.ChannelMultiplier),
+			"scale":     string(scale),
 		},
 	}
+	if account != "" {
+		// The default account's series are left unlabeled so that they continue the series of earlier versions.
+		seriesName = fmt.Sprintf("vue_kwh{account=%q,dev_gid=%q,chan=%q,scale=%q}", account, fmt.Sprint(ch.DeviceGID), ch.ChannelNum, scale)
+		metric.Labels["account"] = account
+	}
+	return seriesName, metric
+}
+
+// writeTokenMetrics writes the age and expiry of the account's token to every destination as gauges at now.
+// Tokens stored by older versions have no age until they are renewed.
+func writeTokenMetrics(dsts []destination, a *account, now time.Time) error {
+	tok := a.vue.Tokens.Tok.Load()
+	if tok.Expiry.IsZero() {
+		return nil
+	}
+	gauges := []*vmclient.Series{
+		gauge(a, "vue_token_expiry_timestamp_seconds", nil, float64(tok.Expiry.Unix()), now),
+	}
+	if !tok.Issued.IsZero() {
+		gauges = append(gauges, gauge(a, "vue_token_age_seconds", nil, now.Sub(tok.Issued).Seconds(), now))
+	}
+	return writeGauges(dsts, "token metrics", gauges)
+}
 
-	start, found, err := vue.GetHistory(ch.DeviceGID, ch.ChannelNum, since, until, scale, vueclient.EnergyKWh)
+// writeDeviceStatus writes whether each of the account's devices is connected to Emporia, and what it is,
+// to every destination as gauges at now:
+// vue_device_up is 1 or 0, vue_device_offline_since_timestamp_seconds is when a disconnected device was last seen,
+// and vue_device_info is 1 with the model and firmware as labels.
+// EV chargers also get vue_evcharger_on, vue_evcharger_rate_amps, vue_evcharger_max_rate_amps,
+// and vue_evcharger_status, which is 1 with the status as a label.
+func writeDeviceStatus(dsts []destination, a *account, devs []vueclient.Device, now time.Time) error {
+	status, err := a.vue.GetDevicesStatus()
 	if err != nil {
-		return err
+		return fmt.Errorf("device status: %w", err)
+	}
+	var gauges []*vmclient.Series
+	for _, conn := range status.DevicesConnected {
+		labels := map[string]string{"dev_gid": fmt.Sprint(conn.DeviceGID)}
+		up := 0.0
+		if conn.Connected {
+			up = 1
+		} else {
+			gauges = append(gauges, gauge(a, "vue_device_offline_since_timestamp_seconds", labels, float64(conn.OfflineSince.Unix()), now))
+		}
+		gauges = append(gauges, gauge(a, "vue_device_up", labels, up, now))
+	}
+	for _, ch := range status.EVChargers {
+		labels := map[string]string{"dev_gid": fmt.Sprint(ch.DeviceGID)}
+		on := 0.0
+		if ch.ChargerOn {
+			on = 1
+		}
+		gauges = append(gauges,
+			gauge(a, "vue_evcharger_on", labels, on, now),
+			gauge(a, "vue_evcharger_rate_amps", labels, float64(ch.ChargingRate), now),
+			gauge(a, "vue_evcharger_max_rate_amps", labels, float64(ch.MaxChargingRate), now),
+			gauge(a, "vue_evcharger_status", map[string]string{"dev_gid": fmt.Sprint(ch.DeviceGID), "status": ch.Status}, 1, now))
+	}
+	for _, dev := range flattenDevices(devs) {
+		gauges = append(gauges, gauge(a, "vue_device_info", map[string]string{
+			"dev_gid":         fmt.Sprint(dev.DeviceGID),
+			"model":           dev.Model,
+			"firmware":        dev.Firmware,
+			"manufacturer_id": dev.ManufacturerDeviceID,
+		}, 1, now))
 	}
-	c := 0
+	return writeGauges(dsts, "device status", gauges)
+}
+
+// gauge returns a series of one sample, labeled with the account if it is named.
+func gauge(a *account, name string, labels map[string]string, v float64, ts time.Time) *vmclient.Series {
+	metric := vmclient.Metric{Name: name, Labels: maps.Clone(labels)}
+	if metric.Labels == nil {
+		metric.Labels = map[string]string{}
+	}
+	if a.name != "" {
+		metric.Labels["account"] = a.name
+	}
+	return &vmclient.Series{Metric: metric, Samples: []vmclient.Sample{{Value: v, Timestamp: ts}}}
+}
+
+// writeGauges writes series of the current value of gauges, described by what, to every destination.
+// Unlike history, they are not tracked by the destinations' cursors.
+func writeGauges(dsts []destination, what string, gauges []*vmclient.Series) error {
+	var errs []error
+	for _, d := range dsts {
+		pusher := d.Open()
+		for _, series := range gauges {
+			if err := pusher.Push(series); err != nil {
+				errs = append(errs, fmt.Errorf("%s: push %s: %w", d.name, what, err))
+			}
+		}
+		if err := pusher.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: push %s: %w", d.name, what, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// chartSamples converts chart usage starting at start into samples, skipping gaps.
+func chartSamples(start time.Time, found []*float64, scale vueclient.Scale) []vmclient.Sample {
+	var samples []vmclient.Sample
 	for _, s := range found {
 		ts := start
 		start = start.Add(scale.Duration())
 		if s == nil {
 			continue
 		}
-		sample := vmclient.Sample{Value: *s, Timestamp: ts}
-		series.Samples = append(series.Samples, sample)
-		c++
-		if len(series.Samples) > 1000 {
-			pusher.Push(&series)
-			series.Samples = nil
+		samples = append(samples, vmclient.Sample{Value: *s, Timestamp: ts})
+	}
+	return samples
+}
+
+// writeSeries writes the samples of one series to a destination and advances its cursor.
+func writeSeries(d destination, seriesName string, metric vmclient.Metric, samples []vmclient.Sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	lastTS := samples[len(samples)-1].Timestamp
+	pusher := d.Open()
+	for len(samples) > 0 {
+		n := min(len(samples), 1000)
+		series := vmclient.Series{Metric: metric, Samples: samples[:n]}
+		if err := pusher.Push(&series); err != nil {
+			return fmt.Errorf("push: %w", err)
 		}
+		samples = samples[n:]
+	}
+	if err := pusher.Close(); err != nil {
+		return fmt.Errorf("push: %w", err)
+	}
+	if err := d.Advance(seriesName, lastTS); err != nil {
+		return fmt.Errorf("advance cursor: %w", err)
 	}
-	if len(series.Samples) > 0 {
-		pusher.Push(&series)
+	stats := pusher.Stats()
+	log.Printf("%s: series %q pushed %d new samples in %d lines", d.name, seriesName, stats.Samples, stats.Series)
+	if bp, ok := pusher.(*vmclient.BufferedPusher); ok && bp.Spooled().Series > 0 {
+		spooled := bp.Spooled()
+		log.Printf("%s: series %q spooled %d new samples for the next run", d.name, seriesName, spooled.Samples)
 	}
-	log.Printf("series %q found %d new samples", seriesName, c)
 	return nil
 }
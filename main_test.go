@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/oauth2"
+
+	"sgrankin.dev/vuescrape/sink"
+	"sgrankin.dev/vuescrape/vmclient"
+	"sgrankin.dev/vuescrape/vueclient"
+	"sgrankin.dev/vuescrape/vueclient/vuefake"
+)
+
+var now = time.Date(2024, 3, 1, 12, 0, 30, 0, time.UTC)
+
+// newTestAccount returns an account of a fake Emporia API.
+func newTestAccount(t *testing.T, name string, api *vuefake.Server) *account {
+	t.Helper()
+	if api == nil {
+		api = &vuefake.Server{Now: func() time.Time { return now }}
+	}
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	base, _ := url.Parse(srv.URL)
+	tok := vueclient.NewAtom(&vueclient.Token{
+		Token:   oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)},
+		IDToken: "id",
+	})
+	return &account{name: name, vue: vueclient.NewClientWith(tok, nil, vueclient.Options{BaseURL: base, Rate: 1000})}
+}
+
+// memSink is a destination that keeps its cursors and the series written to it in memory.
+type memSink struct {
+	mu     sync.Mutex
+	last   map[string]time.Time
+	series []vmclient.Series
+}
+
+func (s *memSink) Last(series string, _ time.Duration) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last[series], nil
+}
+
+func (s *memSink) Advance(series string, ts time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.last == nil {
+		s.last = map[string]time.Time{}
+	}
+	s.last[series] = ts
+	return nil
+}
+
+func (s *memSink) Open() sink.Writer { return &memWriter{s: s} }
+
+// samples returns the samples written to the sink.
+func (s *memSink) samples() []vmclient.Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []vmclient.Sample
+	for _, series := range s.series {
+		out = append(out, series.Samples...)
+	}
+	return out
+}
+
+type memWriter struct {
+	s     *memSink
+	stats vmclient.PushStats
+}
+
+func (w *memWriter) Push(series *vmclient.Series) error {
+	w.s.mu.Lock()
+	defer w.s.mu.Unlock()
+	w.s.series = append(w.s.series, *series)
+	w.stats.Series++
+	w.stats.Samples += len(series.Samples)
+	return nil
+}
+
+func (w *memWriter) Close() error              { return nil }
+func (w *memWriter) Stats() vmclient.PushStats { return w.stats }
+
+func TestExportHistory_Cursors(t *testing.T) {
+	a := newTestAccount(t, "", nil)
+	ch := vueclient.Channel{DeviceGID: 1000, ChannelNum: "1", Name: "Circuit 1", ChannelMultiplier: 1}
+	scale := vueclient.Scale1Minute
+	series, _ := channelSeries("", ch, scale)
+	since := now.Add(-2 * time.Hour)
+
+	fresh := &memSink{}
+	behind := &memSink{last: map[string]time.Time{series: now.Add(-time.Hour)}}
+	ahead := &memSink{last: map[string]time.Time{series: now.Add(-10 * time.Minute)}}
+	current := &memSink{last: map[string]time.Time{series: now}}
+	dsts := []destination{{name: "fresh", Sink: fresh}, {name: "behind", Sink: behind}, {name: "ahead", Sink: ahead}, {name: "current", Sink: current}}
+	if err := exportHistory(dsts, a, ch, since, now, scale); err != nil {
+		t.Fatalf("exportHistory() error = %v", err)
+	}
+
+	// History is fetched once, from where the destination furthest behind left off.
+	all := fresh.samples()
+	if len(all) == 0 {
+		t.Fatal("fresh destination got no samples")
+	}
+	if all[0].Timestamp.Before(since) {
+		t.Fatalf("fresh destination got samples from %v, want samples since %v", all[0].Timestamp, since)
+	}
+	for _, tt := range []struct {
+		name string
+		s    *memSink
+		from time.Time
+	}{
+		{"behind", behind, now.Add(-time.Hour + time.Minute)},
+		{"ahead", ahead, now.Add(-10*time.Minute + time.Minute)},
+	} {
+		// Each destination gets only the samples after its cursor.
+		var want []vmclient.Sample
+		for _, s := range all {
+			if !s.Timestamp.Before(tt.from) {
+				want = append(want, s)
+			}
+		}
+		if diff := cmp.Diff(want, tt.s.samples()); diff != "" {
+			t.Errorf("%s destination samples diff (-want+got):\n%s", tt.name, diff)
+		}
+		if last := tt.s.last[series]; !last.Equal(all[len(all)-1].Timestamp) {
+			t.Errorf("%s destination cursor = %v, want the last sample %v", tt.name, last, all[len(all)-1].Timestamp)
+		}
+	}
+	if got := current.samples(); len(got) != 0 {
+		t.Errorf("current destination got %d samples, want none", len(got))
+	}
+}
+
+// setFlag sets a command line flag for the duration of a test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	f := flag.Lookup(name)
+	old := f.Value.String()
+	if err := f.Value.Set(value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Value.Set(old) })
+}
+
+// useFakeServer points the flags at a fake Emporia API and user pool, signed in to with -username and -passwod,
+// and returns a config directory for the test.
+func useFakeServer(t *testing.T, api *vuefake.Server) string {
+	t.Helper()
+	srv := httptest.NewServer(newFakeServer("user", "password", "", api))
+	t.Cleanup(srv.Close)
+	setFlag(t, "vue-api", srv.URL)
+	setFlag(t, "cognito-endpoint", srv.URL)
+	setFlag(t, "username", "user")
+	setFlag(t, "passwod", "password")
+	return t.TempDir()
+}
+
+// stdout returns what f prints to standard output.
+func stdout(t *testing.T, f func()) string {
+	t.Helper()
+	tmp, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	old := os.Stdout
+	os.Stdout = tmp
+	defer func() { os.Stdout = old }()
+	f()
+	bs, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(bs)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// runOutlet implements the outlet command: it lists the smart plugs of every account with their state,
+// or turns one of them on or off.
+func runOutlet(configDir string, args []string) (err error) {
+	fs := flag.NewFlagSet("outlet", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] outlet [DEVICE_GID on|off]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	var gid vueclient.DeviceGID
+	var on bool
+	switch fs.NArg() {
+	case 0:
+	case 2:
+		n, err := strconv.Atoi(fs.Arg(0))
+		if err != nil {
+			return fmt.Errorf("outlet: bad device GID %q", fs.Arg(0))
+		}
+		gid = vueclient.DeviceGID(n)
+		switch fs.Arg(1) {
+		case "on":
+			on = true
+		case "off":
+		default:
+			return fmt.Errorf("outlet: state must be on or off, not %q", fs.Arg(1))
+		}
+	default:
+		fs.Usage()
+		return errors.New("outlet: want no arguments or a device GID and a state")
+	}
+
+	accts, err := newAccounts(configDir)
+	if err != nil {
+		return err
+	}
+	defer func() { err = errors.Join(err, closeAccounts(accts)) }()
+	var errs []error
+	for _, a := range accts {
+		outlets, err := a.vue.GetOutlets()
+		if err != nil {
+			errs = append(errs, a.wrap(err))
+			continue
+		}
+		for _, o := range outlets {
+			if gid == 0 {
+				printOutlet(a, &o)
+				continue
+			}
+			if o.DeviceGID != gid {
+				continue
+			}
+			// Device GIDs are unique across accounts, so this is the only outlet to set.
+			o.OutletOn = on
+			set, err := a.vue.SetOutlet(&o)
+			if err != nil {
+				return a.wrap(err)
+			}
+			printOutlet(a, set)
+			return nil
+		}
+	}
+	if gid != 0 {
+		errs = append(errs, fmt.Errorf("outlet: no outlet %d", gid))
+	}
+	return errors.Join(errs...)
+}
+
+// printOutlet prints the state of an outlet as DEVICE_GID on|off, preceded by the account if it is named.
+func printOutlet(a *account, o *vueclient.Outlet) {
+	state := "off"
+	if o.OutletOn {
+		state = "on"
+	}
+	if a.name != "" {
+		fmt.Printf("%s\t", a.name)
+	}
+	fmt.Printf("%d\t%s\n", o.DeviceGID, state)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"sgrankin.dev/vuescrape/vueclient/vuefake"
+)
+
+func TestRunOutlet(t *testing.T) {
+	configDir := useFakeServer(t, &vuefake.Server{})
+	for _, tt := range []struct {
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{nil, "1001\ton\n", false},
+		{[]string{"1001", "off"}, "1001\toff\n", false},
+		{nil, "1001\toff\n", false},
+		{[]string{"1001", "on"}, "1001\ton\n", false},
+		{[]string{"1000", "off"}, "", true},
+		{[]string{"1001", "dim"}, "", true},
+	} {
+		var err error
+		got := stdout(t, func() { err = runOutlet(configDir, tt.args) })
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("outlet %q error = %v, wantErr %v", tt.args, err, tt.wantErr)
+		}
+		if got != tt.want {
+			t.Errorf("outlet %q printed %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
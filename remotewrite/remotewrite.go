@@ -0,0 +1,187 @@
+// Package remotewrite pushes series using the Prometheus remote write protocol.
+//
+// Any receiver of remote write 1.0 requests can be used: Prometheus with the remote write receiver enabled,
+// Mimir, Thanos Receive, VictoriaMetrics, etc.
+// See the [spec] for details.
+//
+// [spec]: https://prometheus.io/docs/concepts/remote_write_spec/
+package remotewrite
+
+import (
+	"bytes"
+	"cmp"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"sgrankin.dev/vuescrape/vmclient"
+)
+
+// Client is a remote write client.
+type Client struct {
+	// URL is the remote write endpoint, e.g. http://prometheus:9090/api/v1/write.
+	URL url.URL
+
+	// HTTPClient is used to make requests.
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Username and Password, if set, are sent with every request using basic auth.
+	Username string
+	Password string
+	// BearerToken, if set, is sent with every request and takes precedence over basic auth.
+	BearerToken string
+	// Header is added to every request, e.g. X-Scope-OrgID for Mimir.
+	Header http.Header
+}
+
+// Options configures a [Pusher].
+// Zero values select the defaults.
+type Options struct {
+	// MaxSamples bounds the number of samples in a single write request.
+	MaxSamples int // Default: 10000.
+
+	// RetryPolicy configures how a failed request is resent.
+	// Per the spec, only 5xx and 429 responses are retried.
+	vmclient.RetryPolicy
+}
+
+func (o *Options) withDefaults() Options {
+	out := *o
+	if out.MaxSamples <= 0 {
+		out.MaxSamples = 10000
+	}
+	return out
+}
+
+// Pusher batches series into write requests.
+type Pusher struct {
+	c    *Client
+	opts Options
+
+	batch   []byte // Encoded WriteRequest.timeseries fields.
+	pending vmclient.PushStats
+	sent    vmclient.PushStats
+}
+
+// Push starts a batch of writes.
+func (c *Client) Push(opts Options) *Pusher {
+	return &Pusher{c: c, opts: opts.withDefaults()}
+}
+
+// Push adds a series to the current batch, sending the batch if it is full.
+// Series longer than the batch size are split across requests.
+func (p *Pusher) Push(s *vmclient.Series) error {
+	samples := s.Samples
+	for len(samples) > 0 {
+		n := min(len(samples), p.opts.MaxSamples-p.pending.Samples)
+		p.batch = appendTimeSeries(p.batch, &s.Metric, samples[:n])
+		p.pending.Series++
+		p.pending.Samples += n
+		samples = samples[n:]
+		if p.pending.Samples >= p.opts.MaxSamples {
+			if err := p.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Flush sends the current batch, retrying as configured.
+func (p *Pusher) Flush() error {
+	if p.pending.Series == 0 {
+		return nil
+	}
+	body := snappy.Encode(nil, p.batch)
+	pending := p.pending
+	p.batch = p.batch[:0]
+	p.pending = vmclient.PushStats{}
+
+	if err := p.opts.RetryPolicy.Do("remote write", func() error { return p.c.write(body) }); err != nil {
+		return err
+	}
+	p.sent.Series += pending.Series
+	p.sent.Samples += pending.Samples
+	return nil
+}
+
+// Close flushes any buffered series.
+func (p *Pusher) Close() error {
+	if p == nil {
+		return nil
+	}
+	return p.Flush()
+}
+
+// Stats returns the counts of data acknowledged by the server.
+func (p *Pusher) Stats() vmclient.PushStats {
+	return p.sent
+}
+
+func (c *Client) write(body []byte) error {
+	a := vmclient.Auth{Username: c.Username, Password: c.Password, BearerToken: c.BearerToken, Header: c.Header}
+	req, err := a.NewRequest("POST", c.URL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	return vmclient.Send(c.HTTPClient, req)
+}
+
+// Field numbers from prometheus/prompb/types.proto and remote.proto.
+const (
+	writeRequestTimeseries = 1
+
+	timeSeriesLabels  = 1
+	timeSeriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+)
+
+// appendTimeSeries appends a WriteRequest.timeseries field to b.
+func appendTimeSeries(b []byte, m *vmclient.Metric, samples []vmclient.Sample) []byte {
+	var ts []byte
+	for _, l := range labels(m) {
+		var lb []byte
+		lb = protowire.AppendTag(lb, labelName, protowire.BytesType)
+		lb = protowire.AppendString(lb, l[0])
+		lb = protowire.AppendTag(lb, labelValue, protowire.BytesType)
+		lb = protowire.AppendString(lb, l[1])
+		ts = protowire.AppendTag(ts, timeSeriesLabels, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, lb)
+	}
+	for _, s := range samples {
+		var sb []byte
+		sb = protowire.AppendTag(sb, sampleValue, protowire.Fixed64Type)
+		sb = protowire.AppendFixed64(sb, math.Float64bits(s.Value))
+		sb = protowire.AppendTag(sb, sampleTimestamp, protowire.VarintType)
+		sb = protowire.AppendVarint(sb, uint64(s.Timestamp.UnixMilli()))
+		ts = protowire.AppendTag(ts, timeSeriesSamples, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, sb)
+	}
+	b = protowire.AppendTag(b, writeRequestTimeseries, protowire.BytesType)
+	return protowire.AppendBytes(b, ts)
+}
+
+// labels returns the metric's name and non-empty labels as name/value pairs sorted by name, as required by the spec.
+func labels(m *vmclient.Metric) [][2]string {
+	out := [][2]string{{"__name__", m.Name}}
+	for k, v := range m.Labels {
+		if v != "" {
+			out = append(out, [2]string{k, v})
+		}
+	}
+	slices.SortFunc(out, func(a, b [2]string) int { return cmp.Compare(a[0], b[0]) })
+	return out
+}
@@ -0,0 +1,123 @@
+package remotewrite
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"sgrankin.dev/vuescrape/vmclient"
+)
+
+// decoded is a minimal view of a decoded WriteRequest.
+type decoded struct {
+	Labels  [][2]string
+	Samples []vmclient.Sample
+}
+
+func decodeWriteRequest(t *testing.T, b []byte) []decoded {
+	t.Helper()
+	var out []decoded
+	fields(t, b, func(num protowire.Number, v []byte, _ uint64) {
+		var ts decoded
+		fields(t, v, func(num protowire.Number, v []byte, _ uint64) {
+			switch num {
+			case timeSeriesLabels:
+				var l [2]string
+				fields(t, v, func(num protowire.Number, v []byte, _ uint64) { l[num-1] = string(v) })
+				ts.Labels = append(ts.Labels, l)
+			case timeSeriesSamples:
+				var s vmclient.Sample
+				fields(t, v, func(num protowire.Number, _ []byte, x uint64) {
+					if num == sampleValue {
+						s.Value = math.Float64frombits(x)
+					} else {
+						s.Timestamp = time.UnixMilli(int64(x))
+					}
+				})
+				ts.Samples = append(ts.Samples, s)
+			}
+		})
+		out = append(out, ts)
+	})
+	return out
+}
+
+func fields(t *testing.T, b []byte, f func(protowire.Number, []byte, uint64)) {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("bad tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			f(num, v, 0)
+			b = b[n:]
+		case protowire.Fixed64Type:
+			v, n := protowire.ConsumeFixed64(b)
+			f(num, nil, v)
+			b = b[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			f(num, nil, v)
+			b = b[n:]
+		default:
+			t.Fatalf("unexpected wire type %v", typ)
+		}
+	}
+}
+
+func TestPusher(t *testing.T) {
+	var got []decoded
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "snappy" {
+			t.Errorf("Content-Encoding = %q", r.Header.Get("Content-Encoding"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		raw, err := snappy.Decode(nil, body)
+		if err != nil {
+			t.Errorf("snappy: %v", err)
+		}
+		got = append(got, decodeWriteRequest(t, raw)...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	c := &Client{URL: *u}
+	p := c.Push(Options{MaxSamples: 2})
+	s := vmclient.Series{
+		Metric: vmclient.Metric{Name: "vue_kwh", Labels: map[string]string{"chan": "1", "name": ""}},
+		Samples: []vmclient.Sample{
+			{Value: 1.5, Timestamp: time.UnixMilli(1000)},
+			{Value: 2.5, Timestamp: time.UnixMilli(2000)},
+			{Value: 3.5, Timestamp: time.UnixMilli(3000)},
+		},
+	}
+	if err := p.Push(&s); err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	labels := [][2]string{{"__name__", "vue_kwh"}, {"chan", "1"}}
+	want := []decoded{
+		{labels, s.Samples[:2]},
+		{labels, s.Samples[2:]},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("write requests diff (-want+got):\n%s", diff)
+	}
+	if got, want := p.Stats(), (vmclient.PushStats{Series: 2, Samples: 3}); got != want {
+		t.Errorf("Stats() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// runReplay implements the replay command: history recorded in archive files is written to the destinations
+// without contacting Emporia.
+//
+// Archived chart pages are written as they are, regardless of the destinations' cursors,
+// so that a replay can fill gaps behind newer data.
+func runReplay(configDir string, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] replay ARCHIVE...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	account := fs.String("account", "", "Account `name` to label the replayed series with, and its @tenant if any, as for -accounts.")
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("replay: no archive files")
+	}
+	var name, tenant string
+	if *account != "" {
+		var err error
+		if name, tenant, err = parseAccount(*account); err != nil {
+			return fmt.Errorf("replay: %w", err)
+		}
+	}
+	dsts, err := newDestinations(configDir)
+	if err != nil {
+		return err
+	}
+	dsts = replaySpools(withTenant(dsts, tenant))
+	r := &replayer{dsts: dsts, account: name, channels: map[channelKey]vueclient.Channel{}}
+	var errs []error
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		err = vueclient.ReadArchive(f, func(rec *vueclient.ArchiveRecord) error {
+			errs = append(errs, r.replay(rec))
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	log.Printf("replayed %d chart pages", r.pages)
+	return errors.Join(errs...)
+}
+
+type channelKey struct {
+	dev vueclient.DeviceGID
+	num string
+}
+
+type replayer struct {
+	dsts    []destination
+	account string
+	// channels holds the metadata from the latest archived device list.
+	channels map[channelKey]vueclient.Channel
+	pages    int
+}
+
+// replay writes out a single archived response.  Responses other than device lists and energy charts are skipped.
+func (r *replayer) replay(rec *vueclient.ArchiveRecord) error {
+	if rec.Status != 200 || rec.Body == nil {
+		return nil
+	}
+	// Archives written before paths were recorded with a leading slash lack it.
+	path := "/" + strings.TrimPrefix(rec.Path, "/")
+	switch {
+	case path == "/customers/devices":
+		var body struct {
+			Devices []vueclient.Device `json:"devices"`
+		}
+		if err := json.Unmarshal(rec.Body, &body); err != nil {
+			return fmt.Errorf("device list from %s: %w", rec.Time, err)
+		}
+		for _, ch := range channels(body.Devices) {
+			r.channels[channelKey{ch.DeviceGID, ch.ChannelNum}] = ch
+		}
+	case path == "/AppAPI" && rec.Query.Get("apiMethod") == "getChartUsage":
+		if vueclient.EnergyUnit(rec.Query.Get("energyUnit")) != vueclient.EnergyKWh {
+			return nil
+		}
+		gid, err := strconv.Atoi(rec.Query.Get("deviceGid"))
+		if err != nil {
+			return fmt.Errorf("chart from %s: bad deviceGid: %w", rec.Time, err)
+		}
+		key := channelKey{vueclient.DeviceGID(gid), rec.Query.Get("channel")}
+		ch, ok := r.channels[key]
+		if !ok {
+			// The device list wasn't archived; labels that come from it will be empty.
+			ch = vueclient.Channel{DeviceGID: key.dev, ChannelNum: key.num}
+		}
+		var chart vueclient.ChartUsage
+		if err := json.Unmarshal(rec.Body, &chart); err != nil {
+			return fmt.Errorf("chart from %s: %w", rec.Time, err)
+		}
+		scale := vueclient.Scale(rec.Query.Get("scale"))
+		if _, ok := scale.LookupPageSize(); !ok {
+			return nil // Not exported by vuescrape.
+		}
+		seriesName, metric := channelSeries(r.account, ch, scale)
+		samples := chartSamples(chart.FirstUsageInstant, chart.UsageList, scale)
+		r.pages++
+		var errs []error
+		for _, d := range r.dsts {
+			if err := writeSeries(d, seriesName, metric, samples); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", d.name, seriesName, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"sgrankin.dev/vuescrape/vmclient"
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// samplesByMetric groups the samples written to s by their labels, ignoring how they were split into pushes.
+func samplesByMetric(s *memSink) map[string][]vmclient.Sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := map[string][]vmclient.Sample{}
+	for _, series := range s.series {
+		key := fmt.Sprint(series.Metric)
+		out[key] = append(out[key], series.Samples...)
+	}
+	return out
+}
+
+// replayFile replays the archive at path into a new memSink.
+func replayFile(t *testing.T, name, path string) (*memSink, *replayer) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	replayed := &memSink{}
+	r := &replayer{dsts: []destination{{name: "replayed", Sink: replayed}}, account: name, channels: map[channelKey]vueclient.Channel{}}
+	if err := vueclient.ReadArchive(f, r.replay); err != nil {
+		t.Fatalf("ReadArchive() error = %v", err)
+	}
+	return replayed, r
+}
+
+func TestReplay(t *testing.T) {
+	a := newTestAccount(t, "home", nil)
+	path := filepath.Join(t.TempDir(), "home.json.gz")
+	archive, err := vueclient.CreateArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.vue.Archive = archive
+
+	// Record a live export, with the device list first so that the replayed series get the same labels.
+	devs, err := a.vue.GetDevices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replay writes whole pages, so export from the start of one.
+	since := now.Add(-2 * time.Hour).Truncate(time.Minute)
+	// Charts that failed or are in other units are archived too, but must not be replayed.
+	chart := fmt.Sprintf(`{"firstUsageInstant":%q,"usageList":[1,2,3]}`, since.Format(time.RFC3339))
+	for _, rec := range []struct {
+		status int
+		unit   vueclient.EnergyUnit
+	}{
+		{500, vueclient.EnergyKWh},
+		{200, "AmpHours"},
+	} {
+		u := &url.URL{Path: "/AppAPI", RawQuery: url.Values{
+			"apiMethod":  {"getChartUsage"},
+			"deviceGid":  {"1000"},
+			"channel":    {"1"},
+			"scale":      {string(vueclient.Scale1Minute)},
+			"energyUnit": {string(rec.unit)},
+		}.Encode()}
+		if err := archive.Record(u, rec.status, []byte(chart)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	live := &memSink{}
+	dsts := []destination{{name: "live", Sink: live}}
+	for _, ch := range channels(devs) {
+		if err := exportHistory(dsts, a, ch, since, now, vueclient.Scale1Minute); err != nil {
+			t.Fatalf("exportHistory() error = %v", err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := samplesByMetric(live)
+	if len(want) == 0 {
+		t.Fatal("live export wrote nothing")
+	}
+
+	replayed, r := replayFile(t, a.name, path)
+	if diff := cmp.Diff(want, samplesByMetric(replayed)); diff != "" {
+		t.Errorf("replayed series differ from the live export (-live +replayed):\n%s", diff)
+	}
+
+	// A writer that crashed leaves a truncated tail; the records before it are still replayed.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := filepath.Join(t.TempDir(), "truncated.json.gz")
+	if err := os.WriteFile(truncated, data[:len(data)-100], 0600); err != nil {
+		t.Fatal(err)
+	}
+	partial, pr := replayFile(t, a.name, truncated)
+	if pr.pages != r.pages-1 {
+		t.Errorf("replayed %d pages from a truncated archive, want %d", pr.pages, r.pages-1)
+	}
+	for key, samples := range samplesByMetric(partial) {
+		if diff := cmp.Diff(want[key], samples); diff != "" {
+			t.Errorf("%s: replayed from a truncated archive differs (-live +replayed):\n%s", key, diff)
+		}
+	}
+}
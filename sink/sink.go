@@ -0,0 +1,128 @@
+// Package sink defines the destinations that exported series are written to.
+//
+// A [Sink] knows where previous runs left off for each series, so that only new samples are written,
+// and accepts batches of new series.
+package sink
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"sgrankin.dev/vuescrape/influx"
+	"sgrankin.dev/vuescrape/internal/cursor"
+	"sgrankin.dev/vuescrape/remotewrite"
+	"sgrankin.dev/vuescrape/vmclient"
+)
+
+// Cursor finds where previous runs left off for each series.
+//
+// Series are identified by a PromQL selector that matches exactly one series,
+// e.g. `vue_kwh{dev_gid="1",chan="1",scale="1MIN"}`.
+type Cursor interface {
+	// Last returns the timestamp of the last sample written for the series within window of now,
+	// or the zero time if there is none.
+	Last(series string, window time.Duration) (time.Time, error)
+	// Advance records that the series was written up to ts.
+	// It is called once a [Writer] holding those samples has been closed without error.
+	Advance(series string, ts time.Time) error
+}
+
+// Writer writes a batch of series.
+// Data is only known to be written once Close returns without error.
+type Writer interface {
+	Push(*vmclient.Series) error
+	Close() error
+	// Stats returns the counts of data acknowledged by the destination.
+	Stats() vmclient.PushStats
+}
+
+// Sink is a destination for series.
+type Sink interface {
+	Cursor
+	// Open starts a batch of writes.
+	Open() Writer
+}
+
+// New combines a cursor and a writer constructor into a Sink.
+func New(c Cursor, open func() Writer) Sink {
+	return &sink{c, open}
+}
+
+type sink struct {
+	Cursor
+	open func() Writer
+}
+
+func (s *sink) Open() Writer { return s.open() }
+
+// VictoriaMetrics returns a sink that imports into VictoriaMetrics and queries it for cursors.
+func VictoriaMetrics(c *vmclient.Client, opts vmclient.BufferOptions) Sink {
+	return New(QueryCursor{c}, func() Writer { return c.PushBuffered(opts) })
+}
+
+// RemoteWrite returns a sink that writes with the Prometheus remote write protocol.
+// Remote write is write-only; cur is used to find where previous runs left off.
+func RemoteWrite(c *remotewrite.Client, opts remotewrite.Options, cur Cursor) Sink {
+	return New(cur, func() Writer { return c.Push(opts) })
+}
+
+// Influx returns a sink that writes InfluxDB line protocol to a server.
+func Influx(c *influx.Client, opts influx.Options, cur Cursor) Sink {
+	return New(cur, func() Writer { return c.Push(opts) })
+}
+
+// InfluxFile returns a sink that appends InfluxDB line protocol to w.
+func InfluxFile(w io.Writer, precision influx.Precision, opts influx.Options, cur Cursor) Sink {
+	return New(cur, func() Writer { return influx.NewWriter(w, precision, opts) })
+}
+
+// QueryCursor queries a Prometheus compatible API for the last sample of each series.
+// The destination is the source of truth, so Advance does nothing.
+type QueryCursor struct {
+	Client *vmclient.Client
+}
+
+// Last implements [Cursor].
+func (c QueryCursor) Last(series string, window time.Duration) (time.Time, error) {
+	// timestamp() of a range vector is MetricsQL only, and in PromQL, timestamp(last_over_time(...)) is the
+	// evaluation time rather than the sample's, so take the latest sample timestamp over a subquery instead.
+	q := fmt.Sprintf("max_over_time(timestamp(%s)[%ds:])", series, int64(window.Seconds()))
+	existing, err := c.Client.Query(q)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// We expect 1 series (the one we asked) or none if it's not yet created.
+	if found, ok := existing.([]vmclient.Series); ok && len(found) == 1 && len(found[0].Samples) == 1 {
+		return time.Unix(int64(found[0].Samples[0].Value), 0), nil
+	}
+	return time.Time{}, nil
+}
+
+// Advance implements [Cursor].
+func (QueryCursor) Advance(string, time.Time) error { return nil }
+
+// FileCursor keeps cursors in a local file, for destinations that cannot be queried.
+type FileCursor struct {
+	File *cursor.File
+}
+
+// Last implements [Cursor].
+func (c FileCursor) Last(series string, _ time.Duration) (time.Time, error) {
+	return c.File.Last(series), nil
+}
+
+// Advance implements [Cursor].
+func (c FileCursor) Advance(series string, ts time.Time) error {
+	return c.File.Advance(series, ts)
+}
+
+var (
+	_ Cursor = QueryCursor{}
+	_ Cursor = FileCursor{}
+
+	_ Writer = (*vmclient.Pusher)(nil)
+	_ Writer = (*vmclient.BufferedPusher)(nil)
+	_ Writer = (*remotewrite.Pusher)(nil)
+	_ Writer = (*influx.Pusher)(nil)
+)
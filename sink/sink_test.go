@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"sgrankin.dev/vuescrape/internal/cursor"
+	"sgrankin.dev/vuescrape/vmclient"
+)
+
+func newQueryCursor(t *testing.T, h http.HandlerFunc) QueryCursor {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return QueryCursor{&vmclient.Client{Dest: *u}}
+}
+
+// rangeTimestamp matches timestamp() applied to a range vector, which only MetricsQL accepts.
+var rangeTimestamp = regexp.MustCompile(`timestamp\([^()]*\[`)
+
+func TestQueryCursor_PromQL(t *testing.T) {
+	var got string
+	c := newQueryCursor(t, func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("query")
+		if rangeTimestamp.MatchString(got) {
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, `{"status":"error","errorType":"bad_data","error":"expected type instant vector in call to function \"timestamp\", got range vector"}`)
+			return
+		}
+		io.WriteString(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1709294430,"1709294400"]}]}}`)
+	})
+	last, err := c.Last(`power{device="1"}`, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("Last() error = %v", err)
+	}
+	if want := `max_over_time(timestamp(power{device="1"})[86400s:])`; got != want {
+		t.Errorf("query = %q, want %q", got, want)
+	}
+	if want := time.Unix(1709294400, 0); !last.Equal(want) {
+		t.Errorf("Last() = %v, want %v", last, want)
+	}
+}
+
+func TestQueryCursor_Last(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		result string
+		want   time.Time
+	}{
+		{"empty", `[]`, time.Time{}},
+		{"one series", `[{"metric":{"__name__":"power"},"value":[1709294430,"1709294400"]}]`, time.Unix(1709294400, 0)},
+		// A selector matching several series can't say where this one left off, so start from the lookback window.
+		{"many series", `[{"metric":{"device":"1"},"value":[1709294430,"1709294400"]},{"metric":{"device":"2"},"value":[1709294430,"1709294340"]}]`, time.Time{}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newQueryCursor(t, func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `{"status":"success","data":{"resultType":"vector","result":`+tt.result+`}}`)
+			})
+			got, err := c.Last("power", time.Hour)
+			if err != nil {
+				t.Fatalf("Last() error = %v", err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Last() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFileCursor_Advance(t *testing.T) {
+	f, err := cursor.Open(filepath.Join(t.TempDir(), "cursors.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := FileCursor{f}
+	ts := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, adv := range []time.Time{ts, ts.Add(-time.Hour), ts} {
+		if err := c.Advance("power", adv); err != nil {
+			t.Fatalf("Advance(%v) error = %v", adv, err)
+		}
+	}
+	if got, err := c.Last("power", time.Hour); err != nil || !got.Equal(ts) {
+		t.Errorf("Last() = %v, %v, want %v", got, err, ts)
+	}
+	if got, _ := c.Last("energy", time.Hour); !got.IsZero() {
+		t.Errorf("Last() of an unwritten series = %v, want zero", got)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sgrankin.dev/vuescrape/influx"
+	"sgrankin.dev/vuescrape/internal/cursor"
+	"sgrankin.dev/vuescrape/remotewrite"
+	"sgrankin.dev/vuescrape/sink"
+	"sgrankin.dev/vuescrape/vmclient"
+)
+
+// destination is a sink selected by flags.
+type destination struct {
+	name string // For logs.
+	sink.Sink
+
+	// vm and spoolDir are the client and spool directory of a VictoriaMetrics destination,
+	// so that accounts with their own tenant can write to it.
+	vm       *vmclient.Client
+	spoolDir string
+}
+
+// newDestinations prepares the destinations selected by flags.
+func newDestinations(configDir string) ([]destination, error) {
+	vms, err := newVMClients()
+	if err != nil {
+		return nil, err
+	}
+	var dsts []destination
+	for _, typ := range strings.Split(*sinkType, ",") {
+		switch typ {
+		case "vm":
+			for _, vm := range vms {
+				dir := *spoolDir
+				if dir != "" && len(vms) > 1 {
+					dir = filepath.Join(dir, vm.Dest.Host)
+				}
+				dsts = append(dsts, newVMDestination(vm, dir))
+			}
+		case "remote-write":
+			d, err := newRemoteWrite(configDir)
+			if err != nil {
+				return nil, err
+			}
+			dsts = append(dsts, d)
+		case "influx":
+			precision, err := influx.ParsePrecision(*influxPrecision)
+			if err != nil {
+				return nil, err
+			}
+			path := *cursorFile
+			if path == "" {
+				path = filepath.Join(configDir, "vuescrape", "cursors-influx.json")
+			}
+			cf, err := cursor.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			cur := sink.FileCursor{File: cf}
+			if *influxFile != "" {
+				f, err := os.OpenFile(*influxFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+				if err != nil {
+					return nil, err
+				}
+				// The file stays open until exit; every write is a complete batch of lines.
+				dsts = append(dsts, destination{name: "influx " + *influxFile, Sink: sink.InfluxFile(f, precision, influx.Options{}, cur)})
+				continue
+			}
+			u, err := url.Parse(*influxURL)
+			if err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid -influx-url %q", *influxURL)
+			}
+			tlsOpts := vmclient.TLSOptions{
+				CAFile:             *influxCA,
+				CertFile:           *influxCert,
+				KeyFile:            *influxKey,
+				InsecureSkipVerify: *influxInsecure,
+			}
+			hc, err := tlsOpts.HTTPClient()
+			if err != nil {
+				return nil, fmt.Errorf("influx: %w", err)
+			}
+			ic := &influx.Client{
+				URL:        *u,
+				Org:        *influxOrg,
+				Bucket:     *influxBucket,
+				Token:      *influxToken,
+				Precision:  precision,
+				HTTPClient: hc,
+			}
+			dsts = append(dsts, destination{name: "influx " + u.Host, Sink: sink.Influx(ic, influx.Options{}, cur)})
+		default:
+			return nil, fmt.Errorf("unknown -sink %q", typ)
+		}
+	}
+	return dsts, nil
+}
+
+// newVMDestination prepares a VictoriaMetrics destination that spools failed imports in spoolDir, if set.
+func newVMDestination(vm *vmclient.Client, spoolDir string) destination {
+	name := "vm " + vm.Dest.Host
+	if vm.TenantID != "" {
+		name += " tenant " + vm.TenantID
+	}
+	opts := vmclient.BufferOptions{SpoolDir: spoolDir}
+	return destination{name: name, Sink: sink.VictoriaMetrics(vm, opts), vm: vm, spoolDir: spoolDir}
+}
+
+// replaySpools replays the spool of each destination, returning those that succeeded.
+// Destinations that fail are logged and left out, so that they don't hold up the rest for this run.
+func replaySpools(dsts []destination) []destination {
+	var ok []destination
+	for i := range dsts {
+		if err := dsts[i].replaySpool(); err != nil {
+			log.Printf("skipping %s for this run: %v", dsts[i].name, err)
+			continue
+		}
+		ok = append(ok, dsts[i])
+	}
+	return ok
+}
+
+// replaySpool sends the imports that earlier runs spooled for a VictoriaMetrics destination.
+// Call it before querying the destination's cursors, so that spooled data is not fetched again.
+func (d *destination) replaySpool() error {
+	if d.spoolDir == "" {
+		return nil
+	}
+	n, err := d.vm.ReplaySpool(d.spoolDir, vmclient.BufferOptions{SpoolDir: d.spoolDir})
+	if n > 0 {
+		log.Printf("replayed %d spooled imports to %s", n, d.name)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: replaying spool: %w", d.name, err)
+	}
+	return nil
+}
+
+// withTenant returns the destinations of an account with its own VictoriaMetrics cluster tenant.
+// VictoriaMetrics destinations are replaced by ones writing to the tenant, each spooling to a directory of its own;
+// other destinations are shared by all accounts.
+func withTenant(dsts []destination, tenant string) []destination {
+	if tenant == "" {
+		return dsts
+	}
+	var out []destination
+	for _, d := range dsts {
+		if d.vm == nil || d.vm.TenantID == tenant {
+			out = append(out, d)
+			continue
+		}
+		vm := *d.vm
+		vm.TenantID = tenant
+		dir := d.spoolDir
+		if dir != "" {
+			dir = filepath.Join(dir, "tenant-"+strings.ReplaceAll(tenant, ":", "-"))
+		}
+		out = append(out, newVMDestination(&vm, dir))
+	}
+	return out
+}
+
+// newRemoteWrite prepares the remote write destination selected by flags.
+// It has its own credentials and TLS settings, and its own cursor: the receiver's query API, or a file if it has none.
+func newRemoteWrite(configDir string) (destination, error) {
+	u, err := url.Parse(*remoteWriteURL)
+	if err != nil || u.Host == "" {
+		return destination{}, fmt.Errorf("invalid -remote-write-url %q", *remoteWriteURL)
+	}
+	tlsOpts := vmclient.TLSOptions{
+		CAFile:             *remoteWriteCA,
+		CertFile:           *remoteWriteCert,
+		KeyFile:            *remoteWriteKey,
+		InsecureSkipVerify: *remoteWriteInsecure,
+	}
+	hc, err := tlsOpts.HTTPClient()
+	if err != nil {
+		return destination{}, fmt.Errorf("remote write: %w", err)
+	}
+	rw := &remotewrite.Client{
+		URL:         *u,
+		HTTPClient:  hc,
+		Username:    *remoteWriteUsername,
+		Password:    *remoteWritePassword,
+		BearerToken: *remoteWriteBearerToken,
+		Header:      http.Header(remoteWriteHeader),
+	}
+	var cur sink.Cursor
+	if *remoteWriteQueryURL != "" {
+		q, err := url.Parse(*remoteWriteQueryURL)
+		if err != nil || q.Host == "" {
+			return destination{}, fmt.Errorf("invalid -remote-write-query-url %q", *remoteWriteQueryURL)
+		}
+		cur = sink.QueryCursor{Client: &vmclient.Client{
+			Dest:        *q,
+			HTTPClient:  hc,
+			Username:    rw.Username,
+			Password:    rw.Password,
+			BearerToken: rw.BearerToken,
+			Header:      rw.Header,
+		}}
+	} else {
+		path := *remoteWriteCursorFile
+		if path == "" {
+			path = filepath.Join(configDir, "vuescrape", "cursors-remote-write.json")
+		}
+		cf, err := cursor.Open(path)
+		if err != nil {
+			return destination{}, err
+		}
+		cur = sink.FileCursor{File: cf}
+	}
+	return destination{name: "remote-write " + u.Host, Sink: sink.RemoteWrite(rw, remotewrite.Options{}, cur)}, nil
+}
+
+// newVMClients builds a client for every -dest.
+// All of them share the connection settings from flags.
+func newVMClients() ([]*vmclient.Client, error) {
+	tlsOpts := vmclient.TLSOptions{
+		CAFile:             *destCA,
+		CertFile:           *destCert,
+		KeyFile:            *destKey,
+		InsecureSkipVerify: *destInsecure,
+	}
+	hc, err := tlsOpts.HTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	hosts := strings.Split(*dest, ",")
+	if len(hosts) > 1 && (*destInsert != "" || *destSelect != "") {
+		return nil, fmt.Errorf("-dest-insert and -dest-select cannot be used with multiple -dest")
+	}
+	var vms []*vmclient.Client
+	for _, host := range hosts {
+		vm := &vmclient.Client{
+			Dest:        url.URL{Scheme: *destScheme, Host: host},
+			TenantID:    *destTenant,
+			HTTPClient:  hc,
+			Username:    *destUsername,
+			Password:    *destPassword,
+			BearerToken: *destBearerToken,
+			Header:      http.Header(destHeader),
+		}
+		if *destInsert != "" {
+			vm.InsertDest = &url.URL{Scheme: *destScheme, Host: *destInsert}
+		}
+		if *destSelect != "" {
+			vm.SelectDest = &url.URL{Scheme: *destScheme, Host: *destSelect}
+		}
+		vms = append(vms, vm)
+	}
+	return vms, nil
+}
+
+// headerFlag collects repeated `Name: value` flags.
+type headerFlag http.Header
+
+func (h headerFlag) String() string {
+	var b strings.Builder
+	http.Header(h).Write(&b)
+	return b.String()
+}
+
+func (h headerFlag) Set(v string) error {
+	name, value, ok := strings.Cut(v, ":")
+	if !ok {
+		return fmt.Errorf("header %q is not in the form `Name: value`", v)
+	}
+	http.Header(h).Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	return nil
+}
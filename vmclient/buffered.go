@@ -0,0 +1,190 @@
+package vmclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sgrankin.dev/vuescrape/internal/atomicfile"
+)
+
+// BufferOptions configures a [BufferedPusher].
+// Zero values select the defaults.
+type BufferOptions struct {
+	// MaxChunkBytes bounds the uncompressed size of a single import request.
+	MaxChunkBytes int // Default: 4MiB.
+	// MaxChunkSeries bounds the number of series lines in a single import request.
+	MaxChunkSeries int // Default: 10000.
+
+	// RetryPolicy configures how a failed chunk is resent.
+	RetryPolicy
+
+	// SpoolDir, if set, is where chunks are written once retries are exhausted.
+	// Spooled chunks are sent by [Client.ReplaySpool].
+	SpoolDir string
+}
+
+func (o *BufferOptions) withDefaults() BufferOptions {
+	out := *o
+	if out.MaxChunkBytes <= 0 {
+		out.MaxChunkBytes = 4 << 20
+	}
+	if out.MaxChunkSeries <= 0 {
+		out.MaxChunkSeries = 10000
+	}
+	out.RetryPolicy = out.RetryPolicy.withDefaults()
+	return out
+}
+
+// BufferedPusher batches series into bounded gzip chunks and imports each chunk as a separate request.
+//
+// Unlike [Pusher], a chunk is fully buffered before it is sent, so failed requests can be retried
+// and, if a spool directory is configured, persisted for a later run.
+type BufferedPusher struct {
+	c    *Client
+	opts BufferOptions
+
+	buf   bytes.Buffer
+	gzw   *gzip.Writer
+	raw   int       // Uncompressed bytes in the current chunk.
+	chunk PushStats // Contents of the current chunk.
+
+	sent    PushStats
+	spooled PushStats
+}
+
+// PushBuffered starts a buffered import.
+func (c *Client) PushBuffered(opts BufferOptions) *BufferedPusher {
+	p := &BufferedPusher{c: c, opts: opts.withDefaults()}
+	p.gzw = gzip.NewWriter(&p.buf)
+	return p
+}
+
+// Push adds a series to the current chunk, sending the chunk if it is full.
+func (p *BufferedPusher) Push(s *Series) error {
+	line, err := s.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	if p.chunk.Series > 0 && p.raw+len(line)+1 > p.opts.MaxChunkBytes {
+		if err := p.Flush(); err != nil {
+			return err
+		}
+	}
+	p.gzw.Write(line)
+	p.gzw.Write([]byte{'\n'})
+	p.raw += len(line) + 1
+	p.chunk.Series++
+	p.chunk.Samples += len(s.Samples)
+	if p.chunk.Series >= p.opts.MaxChunkSeries {
+		return p.Flush()
+	}
+	return nil
+}
+
+// Flush sends the current chunk, retrying as configured.
+// If all attempts fail and a spool directory is set, the chunk is spooled and no error is returned.
+func (p *BufferedPusher) Flush() error {
+	if p.chunk.Series == 0 {
+		return nil
+	}
+	if err := p.gzw.Close(); err != nil {
+		return err
+	}
+	body := bytes.Clone(p.buf.Bytes())
+	chunk := p.chunk
+	p.buf.Reset()
+	p.gzw.Reset(&p.buf)
+	p.raw = 0
+	p.chunk = PushStats{}
+
+	err := p.c.importRetry(body, p.opts)
+	if err == nil {
+		p.sent.Series += chunk.Series
+		p.sent.Samples += chunk.Samples
+		return nil
+	}
+	if p.opts.SpoolDir == "" || !Retryable(err) {
+		return err
+	}
+	path, serr := spool(p.opts.SpoolDir, body)
+	if serr != nil {
+		return errors.Join(err, fmt.Errorf("spool: %w", serr))
+	}
+	log.Printf("import failed, spooled %d series to %s: %v", chunk.Series, path, err)
+	p.spooled.Series += chunk.Series
+	p.spooled.Samples += chunk.Samples
+	return nil
+}
+
+// Close flushes any buffered series.
+func (p *BufferedPusher) Close() error {
+	if p == nil {
+		return nil
+	}
+	return p.Flush()
+}
+
+// Stats returns the counts of data acknowledged by the server.
+func (p *BufferedPusher) Stats() PushStats {
+	return p.sent
+}
+
+// Spooled returns the counts of data written to the spool directory instead of the server.
+func (p *BufferedPusher) Spooled() PushStats {
+	return p.spooled
+}
+
+// importRetry sends a gzip chunk, retrying with exponential backoff on retryable failures.
+func (c *Client) importRetry(body []byte, opts BufferOptions) error {
+	return opts.RetryPolicy.Do("import", func() error { return c.importGzip(bytes.NewReader(body)) })
+}
+
+const spoolSuffix = ".json.gz"
+
+// spool writes a chunk into dir under a name that sorts by creation time.
+func spool(dir string, body []byte) (string, error) {
+	path := filepath.Join(dir, fmt.Sprintf("%020d%s", time.Now().UnixNano(), spoolSuffix))
+	return path, atomicfile.WriteFile(path, body, 0600)
+}
+
+// ReplaySpool sends chunks left in dir by a [BufferedPusher], oldest first.
+// Each chunk is removed once imported.  Replay stops at the first chunk that cannot be sent.
+// It returns the number of chunks imported.
+func (c *Client) ReplaySpool(dir string, opts BufferOptions) (int, error) {
+	opts = opts.withDefaults()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), spoolSuffix) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		path := filepath.Join(dir, name)
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return i, err
+		}
+		if err := c.importRetry(body, opts); err != nil {
+			return i, fmt.Errorf("replay %s: %w", path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return i, err
+		}
+	}
+	return len(names), nil
+}
@@ -0,0 +1,77 @@
+package vmclient
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBufferedPusher_SpoolAndReplay(t *testing.T) {
+	fail := true
+	var requests, imported int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		requests++
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		imported++
+		w.WriteHeader(http.StatusNoContent)
+	})
+	opts := BufferOptions{
+		MaxChunkSeries: 2,
+		RetryPolicy:    RetryPolicy{Retries: 1, Backoff: time.Millisecond},
+		SpoolDir:       t.TempDir(),
+	}
+	p := c.PushBuffered(opts)
+	s := Series{Metric: Metric{Name: "METRIC"}, Samples: []Sample{{Value: 1, Timestamp: time.UnixMilli(1)}}}
+	for range 3 {
+		if err := p.Push(&s); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got, want := requests, 4; got != want {
+		t.Errorf("requests = %d, want %d", got, want)
+	}
+	if got, want := p.Spooled(), (PushStats{Series: 3, Samples: 3}); got != want {
+		t.Errorf("Spooled() = %+v, want %+v", got, want)
+	}
+	if got := p.Stats(); got != (PushStats{}) {
+		t.Errorf("Stats() = %+v, want zero", got)
+	}
+
+	fail = false
+	n, err := c.ReplaySpool(opts.SpoolDir, opts)
+	if err != nil {
+		t.Fatalf("ReplaySpool() error = %v", err)
+	}
+	if n != 2 || imported != 2 {
+		t.Errorf("ReplaySpool() = %d, imported %d; want 2, 2", n, imported)
+	}
+	if entries, _ := os.ReadDir(opts.SpoolDir); len(entries) != 0 {
+		t.Errorf("spool not empty after replay: %v", entries)
+	}
+}
+
+func TestBufferedPusher_NoRetryOnClientError(t *testing.T) {
+	requests := 0
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		requests++
+		http.Error(w, "bad", http.StatusBadRequest)
+	})
+	p := c.PushBuffered(BufferOptions{RetryPolicy: RetryPolicy{Backoff: time.Millisecond}, SpoolDir: t.TempDir()})
+	p.Push(&Series{Metric: Metric{Name: "METRIC"}})
+	if err := p.Close(); err == nil {
+		t.Fatal("Close() succeeded, want error")
+	}
+	if requests != 1 {
+		t.Errorf("requests = %d, want 1", requests)
+	}
+}
@@ -1,6 +1,7 @@
 package vmclient
 
 import (
+	"bytes"
 	"compress/gzip"
 	"encoding/json"
 	"errors"
@@ -8,7 +9,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 
 	"golang.org/x/sync/errgroup"
@@ -16,7 +16,29 @@ import (
 
 // Client is a VictoriaMetrics client that can run simple queries and push data.
 type Client struct {
+	// Dest is the base URL of a single-node VictoriaMetrics.
 	Dest url.URL
+
+	// InsertDest and SelectDest, if set, override Dest for imports and queries respectively.
+	// In a cluster, these point at vminsert and vmselect.
+	InsertDest *url.URL
+	SelectDest *url.URL
+	// TenantID, if set, selects the cluster URL layout for that tenant:
+	// /insert/<TenantID>/prometheus/... and /select/<TenantID>/prometheus/...
+	// It may be an account ID or "accountID:projectID".
+	TenantID string
+
+	// HTTPClient is used to make requests.
+	// If nil, http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Username and Password, if set, are sent with every request using basic auth.
+	Username string
+	Password string
+	// BearerToken, if set, is sent with every request and takes precedence over basic auth.
+	BearerToken string
+	// Header is added to every request.
+	Header http.Header
 }
 
 // Returns *Sample for scalars, []Series for vectors.
@@ -61,17 +83,20 @@ func (c *Client) query(q string) (resultType, json.RawMessage, error) {
 	v := url.Values{}
 	v.Set("query", q)
 
-	u := c.Dest.JoinPath("/api/v1/query")
+	u := c.queryURL()
 	u.RawQuery = v.Encode()
 
-	rep, err := http.Get(u.String())
+	req, err := c.newRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", nil, err
+	}
+	rep, err := c.httpClient().Do(req)
 	if err != nil {
 		return "", nil, fmt.Errorf("get: %w", err)
 	}
 	defer rep.Body.Close()
 	if rep.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(rep.Body)
-		return "", nil, fmt.Errorf("get failed with status %s: %s", rep.Status, body)
+		return "", nil, NewHTTPError(rep)
 	}
 	var body struct {
 		Data struct {
@@ -85,6 +110,30 @@ func (c *Client) query(q string) (resultType, json.RawMessage, error) {
 	return body.Data.ResultType, body.Data.Result, nil
 }
 
+// queryURL is the Prometheus instant query endpoint.
+func (c *Client) queryURL() *url.URL {
+	base := &c.Dest
+	if c.SelectDest != nil {
+		base = c.SelectDest
+	}
+	if c.TenantID != "" {
+		return base.JoinPath("/select", c.TenantID, "/prometheus/api/v1/query")
+	}
+	return base.JoinPath("/api/v1/query")
+}
+
+// importURL is the JSON line import endpoint.
+func (c *Client) importURL() *url.URL {
+	base := &c.Dest
+	if c.InsertDest != nil {
+		base = c.InsertDest
+	}
+	if c.TenantID != "" {
+		return base.JoinPath("/insert", c.TenantID, "/prometheus/api/v1/import")
+	}
+	return base.JoinPath("/api/v1/import")
+}
+
 type resultType string
 
 const (
@@ -95,40 +144,60 @@ const (
 	resultTypeString resultType = "string"
 )
 
+// Push starts an import request.
+// Series written with [Pusher.Push] are streamed to VictoriaMetrics as they are encoded;
+// the import is only complete once [Pusher.Close] returns without error.
 func (c *Client) Push() (*Pusher, error) {
 	// TODO: add context and figure out cancelation.
 	r, w := io.Pipe()
 	g := &errgroup.Group{}
-	g.Go(func() error {
-		req, err := http.NewRequest("POST", c.Dest.JoinPath("/api/v1/import").String(), r)
-		if err != nil {
-			return err
-		}
-		req.Header.Set("Content-Encoding", "gzip")
-		resp, err := http.DefaultClient.Do(req)
-		if resp.StatusCode >= 400 {
-			dump, err := httputil.DumpResponse(resp, true)
-			if err != nil {
-				log.Printf("request failed (%s);", resp.Status)
-			} else {
-				log.Printf("request failed (%s); response:\n%s", resp.Status, dump)
-			}
-		}
-		return err
+	g.Go(func() (err error) {
+		// Unblock any pending writes if the request ends before the body is consumed.
+		defer func() { r.CloseWithError(err) }()
+		return c.importGzip(r)
 	})
 	gzw := gzip.NewWriter(w)
 	enc := json.NewEncoder(gzw)
 	enc.SetIndent("", "")
-	return &Pusher{g, w, gzw, enc}, nil
+	return &Pusher{g: g, w: w, gzw: gzw, enc: enc}, nil
+}
+
+// importGzip posts a gzip-compressed JSON line body to the import endpoint.
+func (c *Client) importGzip(body io.Reader) error {
+	req, err := c.newRequest("POST", c.importURL().String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Encoding", "gzip")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return NewHTTPError(resp)
+	}
+	return nil
 }
 
+// Pusher streams series to a single import request.
 type Pusher struct {
 	g   *errgroup.Group
 	w   io.Closer
 	gzw io.WriteCloser
 	enc *json.Encoder
+
+	stats PushStats
 }
 
+// PushStats counts the data written to a [Pusher].
+type PushStats struct {
+	Series  int // Number of series lines written.
+	Samples int // Total number of samples across all series.
+}
+
+// Close finishes the import request and waits for the response.
+// A non-2xx response is reported as an [*HTTPError].
 func (p *Pusher) Close() error {
 	if p == nil {
 		return nil
@@ -140,6 +209,36 @@ func (p *Pusher) Close() error {
 	)
 }
 
+// Push writes a series to the import request.
 func (p *Pusher) Push(s *Series) error {
-	return p.enc.Encode(s)
+	if err := p.enc.Encode(s); err != nil {
+		return err
+	}
+	p.stats.Series++
+	p.stats.Samples += len(s.Samples)
+	return nil
+}
+
+// Stats returns the counts of data pushed so far.
+// Once [Pusher.Close] returns without error, all of it has been acknowledged by the server.
+func (p *Pusher) Stats() PushStats {
+	return p.stats
+}
+
+// HTTPError is returned when VictoriaMetrics responds with an unexpected status.
+type HTTPError struct {
+	StatusCode int
+	Status     string
+	Body       []byte
+}
+
+// NewHTTPError reads the start of a response body into an HTTPError.
+func NewHTTPError(resp *http.Response) *HTTPError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	return &HTTPError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+}
+
+// Error implements error.
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("request failed with status %s: %s", e.Status, bytes.TrimSpace(e.Body))
 }
@@ -0,0 +1,139 @@
+package vmclient
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, h http.HandlerFunc) *Client {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Client{Dest: *u}
+}
+
+func TestPusher_Close(t *testing.T) {
+	var got []byte
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Errorf("gzip: %v", err)
+			return
+		}
+		got, _ = io.ReadAll(zr)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	p, err := c.Push()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Series{
+		Metric:  Metric{Name: "METRIC"},
+		Samples: []Sample{{Value: 1, Timestamp: time.UnixMilli(1)}, {Value: 2, Timestamp: time.UnixMilli(2)}},
+	}
+	for range 2 {
+		if err := p.Push(&s); err != nil {
+			t.Fatalf("Push() error = %v", err)
+		}
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if want := (PushStats{Series: 2, Samples: 4}); p.Stats() != want {
+		t.Errorf("Stats() = %+v, want %+v", p.Stats(), want)
+	}
+	want := s.String() + "\n" + s.String() + "\n"
+	if string(got) != want {
+		t.Errorf("server got %q, want %q", got, want)
+	}
+}
+
+func TestPusher_CloseHTTPError(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		http.Error(w, "cannot parse", http.StatusBadRequest)
+	})
+	p, err := c.Push()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Push(&Series{Metric: Metric{Name: "METRIC"}})
+	err = p.Close()
+	var herr *HTTPError
+	if !errors.As(err, &herr) {
+		t.Fatalf("Close() error = %v, want *HTTPError", err)
+	}
+	if herr.StatusCode != http.StatusBadRequest || string(herr.Body) != "cannot parse\n" {
+		t.Errorf("Close() error = %+v", herr)
+	}
+}
+
+func TestClient_Auth(t *testing.T) {
+	var gotAuth, gotTenant []string
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		gotAuth = append(gotAuth, r.Header.Get("Authorization"))
+		gotTenant = append(gotTenant, r.Header.Get("X-Tenant"))
+		if r.URL.Path == "/api/v1/query" {
+			io.WriteString(w, `{"data":{"resultType":"vector","result":[]}}`)
+		}
+	})
+	c.BearerToken = "TOKEN"
+	c.Header = http.Header{"X-Tenant": {"home"}}
+	if _, err := c.Query("up"); err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	p, err := c.Push()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	for i := range gotAuth {
+		if gotAuth[i] != "Bearer TOKEN" || gotTenant[i] != "home" {
+			t.Errorf("request %d: Authorization = %q, X-Tenant = %q", i, gotAuth[i], gotTenant[i])
+		}
+	}
+	if len(gotAuth) != 2 {
+		t.Errorf("got %d requests, want 2", len(gotAuth))
+	}
+}
+
+func TestClient_URLs(t *testing.T) {
+	insert := &url.URL{Scheme: "http", Host: "vminsert:8480"}
+	sel := &url.URL{Scheme: "http", Host: "vmselect:8481"}
+	tests := []struct {
+		name       string
+		c          Client
+		wantQuery  string
+		wantImport string
+	}{
+		{"single", Client{Dest: url.URL{Scheme: "http", Host: "vm:8428"}},
+			"http://vm:8428/api/v1/query", "http://vm:8428/api/v1/import"},
+		{"tenant", Client{Dest: url.URL{Scheme: "http", Host: "vmauth"}, TenantID: "42"},
+			"http://vmauth/select/42/prometheus/api/v1/query", "http://vmauth/insert/42/prometheus/api/v1/import"},
+		{"cluster", Client{InsertDest: insert, SelectDest: sel, TenantID: "1:2"},
+			"http://vmselect:8481/select/1:2/prometheus/api/v1/query", "http://vminsert:8480/insert/1:2/prometheus/api/v1/import"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.c.queryURL().String(); got != tt.wantQuery {
+				t.Errorf("queryURL() = %v, want %v", got, tt.wantQuery)
+			}
+			if got := tt.c.importURL().String(); got != tt.wantImport {
+				t.Errorf("importURL() = %v, want %v", got, tt.wantImport)
+			}
+		})
+	}
+}
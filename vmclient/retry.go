@@ -0,0 +1,53 @@
+package vmclient
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RetryPolicy configures how failed requests are resent.
+// Zero values select the defaults.
+type RetryPolicy struct {
+	// Retries is the number of times a failed request is resent.
+	Retries int // Default: 5.
+	// Backoff is the delay before the first retry; it doubles on every attempt.
+	Backoff time.Duration // Default: 1s.
+}
+
+func (r RetryPolicy) withDefaults() RetryPolicy {
+	if r.Retries <= 0 {
+		r.Retries = 5
+	}
+	if r.Backoff <= 0 {
+		r.Backoff = time.Second
+	}
+	return r
+}
+
+// Do calls f until it succeeds, fails with an error that is not [Retryable], or the retries run out.
+// Failed attempts that are retried are logged, naming what was attempted.
+func (r RetryPolicy) Do(what string, f func() error) error {
+	r = r.withDefaults()
+	backoff := r.Backoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || !Retryable(err) || attempt >= r.Retries {
+			return err
+		}
+		log.Printf("%s attempt %d failed, retrying in %s: %v", what, attempt+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Retryable reports whether resending the same request might succeed.
+// Client errors other than throttling are not expected to go away.
+func Retryable(err error) bool {
+	var herr *HTTPError
+	if errors.As(err, &herr) {
+		return herr.StatusCode == http.StatusTooManyRequests || herr.StatusCode >= 500
+	}
+	return true
+}
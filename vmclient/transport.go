@@ -0,0 +1,121 @@
+package vmclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+)
+
+// TLSOptions configures TLS for connections to VictoriaMetrics.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of CAs used to verify the server.  If empty, the system pool is used.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key to present to the server.
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify disables server certificate verification.
+	InsecureSkipVerify bool
+}
+
+// Config builds a tls.Config from the options.
+func (o *TLSOptions) Config() (*tls.Config, error) {
+	cfg := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if o.CAFile != "" {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+	}
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		return nil, errors.New("client certificate and key must be set together")
+	}
+	if o.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// HTTPClient returns an http.Client using the TLS options.
+func (o *TLSOptions) HTTPClient() (*http.Client, error) {
+	cfg, err := o.Config()
+	if err != nil {
+		return nil, err
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	return &http.Client{Transport: t}, nil
+}
+
+// Auth is the credentials and extra headers sent with every request to a server.
+type Auth struct {
+	// Username and Password, if set, are sent using basic auth.
+	Username string
+	Password string
+	// BearerToken, if set, takes precedence over basic auth.
+	BearerToken string
+	// Header is added to every request.
+	Header http.Header
+}
+
+// NewRequest creates a request carrying the credentials and headers.
+func (a *Auth) NewRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range a.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	switch {
+	case a.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+a.BearerToken)
+	case a.Username != "" || a.Password != "":
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+	return req, nil
+}
+
+// Send sends a request whose response has no content of interest.
+// A non-2xx response is reported as an [*HTTPError].
+// If hc is nil, http.DefaultClient is used.
+func Send(hc *http.Client, req *http.Request) error {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return NewHTTPError(resp)
+	}
+	return nil
+}
+
+// newRequest creates a request carrying the client's credentials and headers.
+func (c *Client) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	a := Auth{Username: c.Username, Password: c.Password, BearerToken: c.BearerToken, Header: c.Header}
+	return a.NewRequest(method, url, body)
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
@@ -0,0 +1,104 @@
+package vueclient
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveRecord is a single archived API response.
+type ArchiveRecord struct {
+	Time   time.Time  `json:"time"`
+	Path   string     `json:"path"` // Always with a leading slash, such as /AppAPI.
+	Query  url.Values `json:"query,omitempty"`
+	Status int        `json:"status"`
+
+	// Body is the response body if it is valid JSON.  Otherwise, it is stored in Text.
+	Body json.RawMessage `json:"body,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+// Archive writes API responses to a file as gzip-compressed JSON lines.
+type Archive struct {
+	mu  sync.Mutex
+	f   *os.File
+	gzw *gzip.Writer
+	enc *json.Encoder
+}
+
+// CreateArchive opens an archive at path.
+// If the file exists, new records are appended to it as another gzip member.
+func CreateArchive(path string) (*Archive, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	gzw := gzip.NewWriter(f)
+	return &Archive{f: f, gzw: gzw, enc: json.NewEncoder(gzw)}, nil
+}
+
+// Record archives a response to a request for u.
+func (a *Archive) Record(u *url.URL, status int, body []byte) error {
+	// URLs joined onto the API base have no leading slash.
+	rec := ArchiveRecord{
+		Time:   time.Now().UTC(),
+		Path:   "/" + strings.TrimPrefix(u.Path, "/"),
+		Query:  u.Query(),
+		Status: status,
+	}
+	if json.Valid(body) {
+		rec.Body = body
+	} else {
+		rec.Text = string(body)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(&rec); err != nil {
+		return err
+	}
+	// Flush every record so that a crash loses as little as possible.
+	return a.gzw.Flush()
+}
+
+// Close finishes the archive.
+func (a *Archive) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return errors.Join(a.gzw.Close(), a.f.Close())
+}
+
+// ReadArchive calls f for every record in an archive.
+func ReadArchive(r io.Reader, f func(*ArchiveRecord) error) error {
+	gzr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+	dec := json.NewDecoder(gzr)
+	for {
+		var rec ArchiveRecord
+		if err := dec.Decode(&rec); err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			// A truncated tail is left behind if the writer crashed; every complete record was flushed before it.
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := f(&rec); err != nil {
+			return err
+		}
+	}
+}
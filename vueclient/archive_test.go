@@ -0,0 +1,56 @@
+package vueclient
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "archive.jsonl.gz")
+	base, _ := url.Parse("https://api.emporiaenergy.com")
+	u := base.JoinPath("/AppAPI")
+	u.RawQuery = "apiMethod=getChartUsage&channel=1"
+	want := []ArchiveRecord{
+		{Path: "/AppAPI", Query: u.Query(), Status: 200, Body: json.RawMessage(`{"usageList":[1,null]}`)},
+		{Path: "/AppAPI", Query: u.Query(), Status: 502, Text: "<html>Bad Gateway</html>"},
+	}
+	// Two runs appending to the same file, the second of which crashed before closing the archive.
+	for i, rec := range want {
+		a, err := CreateArchive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := []byte(rec.Text)
+		if rec.Body != nil {
+			body = rec.Body
+		}
+		if err := a.Record(u, rec.Status, body); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+		if i == 0 {
+			a.Close()
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var got []ArchiveRecord
+	if err := ReadArchive(f, func(rec *ArchiveRecord) error {
+		got = append(got, *rec)
+		return nil
+	}); err != nil {
+		t.Fatalf("ReadArchive() error = %v", err)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(ArchiveRecord{}, "Time")); diff != "" {
+		t.Errorf("ReadArchive() diff (-want+got):\n%s", diff)
+	}
+}
@@ -1,13 +1,29 @@
 package vueclient
 
-import "sync"
+import (
+	"slices"
+	"sync"
+)
 
 // An Atom holds a value which can be updated atomically.
 // Watchers may be registered to receive updates.
+//
+// Watchers are called after the update, outside of the Atom's lock, so they may Load the value.
+// Updates are delivered to watchers one at a time, in the order they were made,
+// so a watcher that updates the same Atom deadlocks.
 type Atom[T any] struct {
 	mu       sync.RWMutex
 	v        T
-	watchers []func(T, T)
+	watchers []*watcher[T]
+	seq      uint64 // Number of updates made.
+
+	turnMu sync.Mutex
+	turn   uint64        // Number of updates delivered to watchers.
+	turnCh chan struct{} // Closed when turn advances.
+}
+
+type watcher[T any] struct {
+	f func(old, new T)
 }
 
 func NewAtom[T any](v T) *Atom[T] { return &Atom[T]{v: v} }
@@ -19,21 +35,121 @@ func (a *Atom[T]) Load() T {
 	return a.v
 }
 
-// Reset sets the current value.
+// Reset sets the current value and returns the previous one.
+// It is the same as Swap.
 func (a *Atom[T]) Reset(v T) T {
+	return a.Swap(v)
+}
+
+// Swap sets the current value and returns the previous one.
+func (a *Atom[T]) Swap(v T) T {
 	a.mu.Lock()
-	defer a.mu.Unlock()
-	v, a.v = a.v, v
-	// TODO: should watchers be called outside of the lock?
-	for _, w := range a.watchers {
-		w(v, a.v)
+	old := a.v
+	a.v = v
+	a.notify(old, v)
+	return old
+}
+
+// CompareAndSwap sets the value to new if it is old, and reports whether it did.
+// Values are compared with ==, so it panics if T's values are not comparable.
+func (a *Atom[T]) CompareAndSwap(old, new T) bool {
+	a.mu.Lock()
+	if any(a.v) != any(old) {
+		a.mu.Unlock()
+		return false
 	}
+	a.v = new
+	a.notify(old, new)
+	return true
+}
+
+// Update sets the value to f applied to the current value, and returns the new value.
+// f is called with the lock held, so it must not use the Atom.
+func (a *Atom[T]) Update(f func(T) T) T {
+	a.mu.Lock()
+	old := a.v
+	a.v = f(old)
+	v := a.v
+	a.notify(old, v)
 	return v
 }
 
-// Watch registers a function f that will be called whenevr the value is set.
-func (a *Atom[T]) Watch(f func(old T, new T)) {
+// notify unlocks mu, which must be held, and calls the watchers once the earlier updates have been delivered.
+func (a *Atom[T]) notify(old, new T) {
+	seq := a.seq
+	a.seq++
+	watchers := slices.Clone(a.watchers)
+	a.mu.Unlock()
+
+	for {
+		a.turnMu.Lock()
+		if a.turn == seq {
+			a.turnMu.Unlock()
+			break
+		}
+		if a.turnCh == nil {
+			a.turnCh = make(chan struct{})
+		}
+		ch := a.turnCh
+		a.turnMu.Unlock()
+		<-ch
+	}
+	defer func() {
+		a.turnMu.Lock()
+		a.turn++
+		if a.turnCh != nil {
+			close(a.turnCh)
+			a.turnCh = nil
+		}
+		a.turnMu.Unlock()
+	}()
+	for _, w := range watchers {
+		w.f(old, new)
+	}
+}
+
+// Watch registers a function f that will be called whenever the value is set.
+// It returns a function that unregisters f.
+// Once that returns, f is not called for later updates, but it may still be running for an earlier one.
+func (a *Atom[T]) Watch(f func(old T, new T)) (unwatch func()) {
+	w := &watcher[T]{f}
 	a.mu.Lock()
 	defer a.mu.Unlock()
-	a.watchers = append(a.watchers, f)
+	a.watchers = append(a.watchers, w)
+	return func() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.watchers = slices.DeleteFunc(a.watchers, func(x *watcher[T]) bool { return x == w })
+	}
+}
+
+// Subscribe returns a channel that receives the value whenever it is set.
+// The channel holds only the latest value: a receiver that falls behind misses intermediate values.
+// Calling cancel stops the updates and closes the channel.
+func (a *Atom[T]) Subscribe() (updates <-chan T, cancel func()) {
+	ch := make(chan T, 1)
+	var mu sync.Mutex
+	closed := false
+	unwatch := a.Watch(func(_, v T) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		// Replace an unreceived value.  Watchers are called one at a time, so the send can't block.
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- v
+	})
+	return ch, func() {
+		unwatch()
+		mu.Lock()
+		defer mu.Unlock()
+		if !closed {
+			closed = true
+			close(ch)
+		}
+	}
 }
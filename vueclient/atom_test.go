@@ -0,0 +1,124 @@
+package vueclient_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+func TestAtom_Update(t *testing.T) {
+	a := vueclient.NewAtom(0)
+	var mu sync.Mutex
+	var seen []int
+	a.Watch(func(old, new int) {
+		if new != old+1 {
+			t.Errorf("watcher got %d -> %d, want consecutive values", old, new)
+		}
+		// Watchers may load the value.
+		if v := a.Load(); v < new {
+			t.Errorf("Load() in watcher = %d, want at least %d", v, new)
+		}
+		mu.Lock()
+		seen = append(seen, new)
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				a.Update(func(v int) int { return v + 1 })
+			}
+		}()
+	}
+	wg.Wait()
+	if got := a.Load(); got != 1000 {
+		t.Errorf("Load() = %d, want 1000", got)
+	}
+	if len(seen) != 1000 {
+		t.Fatalf("watcher called %d times, want 1000", len(seen))
+	}
+	for i, v := range seen {
+		if v != i+1 {
+			t.Fatalf("watcher got %d as update %d, want updates in order", v, i+1)
+		}
+	}
+}
+
+func TestAtom_CompareAndSwap(t *testing.T) {
+	a := vueclient.NewAtom("a")
+	if a.CompareAndSwap("b", "c") {
+		t.Errorf("CompareAndSwap(b, c) = true with value a")
+	}
+	if !a.CompareAndSwap("a", "c") {
+		t.Errorf("CompareAndSwap(a, c) = false with value a")
+	}
+	if old := a.Swap("d"); old != "c" {
+		t.Errorf("Swap(d) = %q, want c", old)
+	}
+	if got := a.Load(); got != "d" {
+		t.Errorf("Load() = %q, want d", got)
+	}
+}
+
+func TestAtom_WatcherOutsideLock(t *testing.T) {
+	a := vueclient.NewAtom(0)
+	release := make(chan struct{})
+	a.Watch(func(_, _ int) { <-release })
+	go a.Swap(1)
+	// A slow watcher doesn't block Load.
+	for a.Load() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+}
+
+func TestAtom_Unwatch(t *testing.T) {
+	a := vueclient.NewAtom(0)
+	calls := 0
+	unwatch := a.Watch(func(_, _ int) { calls++ })
+	a.Swap(1)
+	unwatch()
+	a.Swap(2)
+	if calls != 1 {
+		t.Errorf("watcher called %d times, want 1", calls)
+	}
+}
+
+func TestAtom_Subscribe(t *testing.T) {
+	a := vueclient.NewAtom(0)
+	updates, cancel := a.Subscribe()
+	a.Swap(1)
+	if got := <-updates; got != 1 {
+		t.Errorf("received %d, want 1", got)
+	}
+	// Values that are not received are replaced by newer ones.
+	a.Swap(2)
+	a.Swap(3)
+	if got := <-updates; got != 3 {
+		t.Errorf("received %d, want the latest value 3", got)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range updates {
+		}
+	}()
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Swap(i)
+		}()
+	}
+	wg.Wait()
+	cancel()
+	<-done // The channel is closed.
+	a.Swap(4)
+}
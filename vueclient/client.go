@@ -1,6 +1,7 @@
 package vueclient
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -23,47 +24,177 @@ var apiBase, _ = url.Parse("https://api.emporiaenergy.com")
 // See [api docs] for details on the protocol.
 // [api docs]: https://github.com/magico13/PyEmVue/blob/master/api_docs.md
 type Client struct {
-	hc *http.Client
+	hc        *http.Client
+	userAgent string
+
+	// BaseURL is the API server.
+	// If nil, the Emporia API at https://api.emporiaenergy.com is used.
+	BaseURL *url.URL
+
+	// Archive, if set, records every API response.
+	Archive *Archive
+
+	// Cognito is the identity provider used to get tokens.
+	// It may be changed before the first request, e.g. to set an Endpoint.
+	Cognito *Cognito
+	// Tokens supplies the tokens that requests are authenticated with.
+	// It may be changed before the first request, e.g. to set a Store.
+	Tokens *CognitoTokenSource
+	// Throttle limits the requests made to the API.
+	// It may be changed before the first request, e.g. to set a Rate.
+	Throttle *Throttle
 }
 
+// NewClient returns a client of the Emporia API with the default options.
 func NewClient(tok *Atom[*Token], authFunc func() (string, string, error)) *Client {
-	return &Client{&http.Client{
-		Transport: &throttledTransport{
-			Limiter: rate.NewLimiter(rate.Limit(10), 1), // 10/s
-			Base: &cognitoAuthTransport{
-				Base: http.DefaultTransport,
-				Source: &CognitoTokenSource{
-					Cognito: &Cognito{
-						Region:   authRegion,
-						ClientID: authClientID,
-						UserPool: userPool,
-					},
-					Tok:      tok,
-					AuthFunc: authFunc,
+	return NewClientWith(tok, authFunc, Options{})
+}
+
+// Options configures a [Client].
+// Zero values select the defaults.
+type Options struct {
+	// BaseURL is the API server.
+	BaseURL *url.URL // Default: https://api.emporiaenergy.com.
+
+	// Region, ClientID and UserPool identify the Cognito user pool that accounts are in.
+	Region   string // Default: us-east-2.
+	ClientID string // Default: Emporia's app.
+	UserPool string // Default: Emporia's pool.
+	// CognitoEndpoint, if set, overrides the URL of the Cognito API.
+	CognitoEndpoint string
+
+	// Transport makes the HTTP requests to the API and to Cognito, e.g. to trace them.
+	Transport http.RoundTripper // Default: http.DefaultTransport.
+	// UserAgent, if set, is sent with API requests.
+	UserAgent string
+	// Rate is the maximum number of API requests per second.
+	Rate rate.Limit // Default: DefaultRate.
+}
+
+func (o *Options) withDefaults() Options {
+	out := *o
+	def := DefaultCognito()
+	if out.Region == "" {
+		out.Region = def.Region
+	}
+	if out.ClientID == "" {
+		out.ClientID = def.ClientID
+	}
+	if out.UserPool == "" {
+		out.UserPool = def.UserPool
+	}
+	if out.Transport == nil {
+		out.Transport = http.DefaultTransport
+	}
+	if out.Rate <= 0 {
+		out.Rate = DefaultRate
+	}
+	return out
+}
+
+// NewClientWith returns a client of the Emporia API configured by opts.
+func NewClientWith(tok *Atom[*Token], authFunc func() (string, string, error), opts Options) *Client {
+	opts = opts.withDefaults()
+	cognito := &Cognito{
+		Region:    opts.Region,
+		ClientID:  opts.ClientID,
+		UserPool:  opts.UserPool,
+		Endpoint:  opts.CognitoEndpoint,
+		Transport: opts.Transport,
+	}
+	tokens := &CognitoTokenSource{
+		Cognito:  cognito,
+		Tok:      tok,
+		AuthFunc: authFunc,
+	}
+	throttle := &Throttle{Rate: opts.Rate}
+	return &Client{
+		hc: &http.Client{
+			// Tokens are renewed outside of the throttle, so that renewals don't count as slow responses.
+			Transport: &cognitoAuthTransport{
+				Source: tokens,
+				Base: &throttledTransport{
+					Throttle: throttle,
+					Base:     opts.Transport,
 				},
-			},
-		}}}
+			}},
+		BaseURL:   opts.BaseURL,
+		userAgent: opts.UserAgent,
+		Cognito:   cognito,
+		Tokens:    tokens,
+		Throttle:  throttle,
+	}
+}
+
+// DefaultCognito returns the user pool that Emporia accounts are in.
+func DefaultCognito() *Cognito {
+	return &Cognito{
+		Region:   authRegion,
+		ClientID: authClientID,
+		UserPool: userPool,
+	}
 }
 
 // GetDevices fetches all the customer devices.
 func (c *Client) GetDevices() ([]Device, error) {
-	u := apiBase.JoinPath("/customers/devices")
-	rep, err := c.hc.Get(u.String())
+	u := c.base().JoinPath("/customers/devices")
+	var body struct {
+		Devices []Device `json:"devices"`
+	}
+	if err := c.getJSON(u, &body); err != nil {
+		return nil, err
+	}
+	return body.Devices, nil
+}
+
+// GetDevicesStatus fetches the current status of the customer's devices.
+func (c *Client) GetDevicesStatus() (*DevicesStatus, error) {
+	u := c.base().JoinPath("/customers/devices/status")
+	var body DevicesStatus
+	if err := c.getJSON(u, &body); err != nil {
+		return nil, err
+	}
+	return &body, nil
+}
+
+// GetOutlets fetches the state of the customer's smart plugs.
+func (c *Client) GetOutlets() ([]Outlet, error) {
+	status, err := c.GetDevicesStatus()
 	if err != nil {
 		return nil, err
 	}
-	defer rep.Body.Close()
-	if rep.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(rep.Body)
-		return nil, fmt.Errorf("request failed: %s: %s", rep.Status, body)
+	return status.Outlets, nil
+}
+
+// SetOutlet changes the settings of a smart plug to those of o, and returns its new state.
+// Start from the plug's current state, as returned by GetOutlets, so that its schedules and other settings are kept.
+func (c *Client) SetOutlet(o *Outlet) (*Outlet, error) {
+	u := c.base().JoinPath("/devices/outlet")
+	var out Outlet
+	if err := c.putJSON(u, o, &out); err != nil {
+		return nil, err
 	}
-	var body struct {
-		Devices []Device `json:"devices"`
+	return &out, nil
+}
+
+// GetEVChargers fetches the state and settings of the customer's EV chargers.
+func (c *Client) GetEVChargers() ([]EVCharger, error) {
+	status, err := c.GetDevicesStatus()
+	if err != nil {
+		return nil, err
 	}
-	if err := json.NewDecoder(rep.Body).Decode(&body); err != nil {
+	return status.EVChargers, nil
+}
+
+// UpdateEVCharger changes the settings of an EV charger to those of ch, and returns its new state.
+// Start from the charger's current state, as returned by GetEVChargers, so that other settings are kept.
+func (c *Client) UpdateEVCharger(ch *EVCharger) (*EVCharger, error) {
+	u := c.base().JoinPath("/devices/evcharger")
+	var out EVCharger
+	if err := c.putJSON(u, ch, &out); err != nil {
 		return nil, err
 	}
-	return body.Devices, nil
+	return &out, nil
 }
 
 // GetUsage fetches the current usage values for the given scale.
@@ -75,17 +206,8 @@ func (c *Client) GetUsage(devices []DeviceGID, instant time.Time, scale Scale, e
 	v.Set("scale", string(scale))
 	v.Set("energyUnit", string(energyUnit))
 
-	u := apiBase.JoinPath("/AppAPI")
+	u := c.base().JoinPath("/AppAPI")
 	u.RawQuery = v.Encode()
-	rep, err := c.hc.Get(u.String())
-	if err != nil {
-		return time.Time{}, nil, err
-	}
-	defer rep.Body.Close()
-	if rep.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(rep.Body)
-		return time.Time{}, nil, fmt.Errorf("request failed: %s: %s", rep.Status, body)
-	}
 	var body struct {
 		DeviceListUsages struct {
 			Instant time.Time     `json:"instant"`
@@ -93,7 +215,7 @@ func (c *Client) GetUsage(devices []DeviceGID, instant time.Time, scale Scale, e
 			Devices []DeviceUsage `json:"devices"`
 		} `json:"deviceListUsages"`
 	}
-	if err := json.NewDecoder(rep.Body).Decode(&body); err != nil {
+	if err := c.getJSON(u, &body); err != nil {
 		return time.Time{}, nil, err
 	}
 	return body.DeviceListUsages.Instant, body.DeviceListUsages.Devices, nil
@@ -137,32 +259,150 @@ func (c *Client) GetHistoryPage(device DeviceGID, channel string, start, end tim
 	v.Set("scale", string(scale))
 	v.Set("energyUnit", string(energyUnit))
 
-	u := apiBase.JoinPath("/AppAPI")
+	u := c.base().JoinPath("/AppAPI")
 	u.RawQuery = v.Encode()
-	rep, err := c.hc.Get(u.String())
-	if err != nil {
+	var body ChartUsage
+	if err := c.getJSON(u, &body); err != nil {
 		return time.Time{}, nil, err
 	}
+	return body.FirstUsageInstant, body.UsageList, nil
+}
+
+// ChartUsage is the response to a getChartUsage request.
+type ChartUsage struct {
+	UsageList         []*float64 `json:"usageList"`
+	FirstUsageInstant time.Time  `json:"firstUsageInstant"`
+}
+
+func (c *Client) base() *url.URL {
+	if c.BaseURL != nil {
+		return c.BaseURL
+	}
+	return apiBase
+}
+
+// getJSON fetches u and decodes the JSON response into v.
+// The response is recorded in the archive, if any, before it is checked.
+func (c *Client) getJSON(u *url.URL, v any) error {
+	return c.doJSON(http.MethodGet, u, nil, v)
+}
+
+// putJSON sends in as JSON to u and decodes the JSON response into out.
+func (c *Client) putJSON(u *url.URL, in, out any) error {
+	return c.doJSON(http.MethodPut, u, in, out)
+}
+
+// doJSON makes a request with in, if not nil, as its JSON body, and decodes the JSON response into out.
+// The response is recorded in the archive, if any, before it is checked.
+func (c *Client) doJSON(method string, u *url.URL, in, out any) error {
+	var reqBody io.Reader
+	if in != nil {
+		bs, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(bs)
+	}
+	req, err := c.newRequest(method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rep, err := c.hc.Do(req)
+	if err != nil {
+		return err
+	}
 	defer rep.Body.Close()
+	body, err := io.ReadAll(rep.Body)
+	if err != nil {
+		return err
+	}
+	if c.Archive != nil {
+		if err := c.Archive.Record(u, rep.StatusCode, body); err != nil {
+			log.Printf("could not archive response: %v", err)
+		}
+	}
 	if rep.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(rep.Body)
-		return time.Time{}, nil, fmt.Errorf("request failed: %s: %s", rep.Status, body)
+		return fmt.Errorf("request failed: %s: %s", rep.Status, body)
 	}
-	var body struct {
-		UsageList         []*float64 `json:"usageList"`
-		FirstUsageInstant time.Time  `json:"firstUsageInstant"`
+	return json.Unmarshal(body, out)
+}
+
+// newRequest creates a request to the API.
+func (c *Client) newRequest(method string, u *url.URL, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
 	}
-	if err := json.NewDecoder(rep.Body).Decode(&body); err != nil {
-		return time.Time{}, nil, err
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
 	}
-	return body.FirstUsageInstant, body.UsageList, nil
+	return req, nil
 }
 
 type Device struct {
+	DeviceGID            DeviceGID `json:"deviceGid"`
+	ManufacturerDeviceID string    `json:"manufacturerDeviceId"`
+	Model                string    `json:"model"`
+	Firmware             string    `json:"firmware"`
+	Channels             []Channel `json:"channels"`
+	Devices              []Device  `json:"devices"`
+}
+
+// DevicesStatus is the response to a /customers/devices/status request.
+type DevicesStatus struct {
+	DevicesConnected []DeviceConnected `json:"devicesConnected"`
+	Outlets          []Outlet          `json:"outlets"`
+	EVChargers       []EVCharger       `json:"evChargers"`
+}
+
+// DeviceConnected is the connectivity of a device to Emporia.
+type DeviceConnected struct {
+	DeviceGID DeviceGID `json:"deviceGid"`
+	Connected bool      `json:"connected"`
+	// OfflineSince is when a disconnected device was last seen.
+	OfflineSince time.Time `json:"offlineSince"`
+}
+
+// EVCharger is the state and settings of an EV charger.
+type EVCharger struct {
 	DeviceGID DeviceGID `json:"deviceGid"`
-	Model     string    `json:"model"`
-	Channels  []Channel `json:"channels"`
-	Devices   []Device  `json:"devices"`
+	LoadGID   int       `json:"loadGid"`
+
+	// ChargerOn is whether charging is enabled; a charger that is off is paused.
+	ChargerOn bool `json:"chargerOn"`
+	// ChargingRate is the current the charger offers the vehicle, in amps.
+	ChargingRate int `json:"chargingRate"`
+	// MaxChargingRate is the highest ChargingRate allowed, in amps, as set for the breaker the charger is on.
+	MaxChargingRate int `json:"maxChargingRate"`
+	// BreakerPIN is the PIN that protects MaxChargingRate from changes.
+	BreakerPIN string `json:"breakerPIN,omitempty"`
+
+	// Status is what the charger is doing, e.g. Charging or Standby.
+	Status string `json:"status"`
+	// Message, FaultText and the Icon fields describe the status for display in the app.
+	Message        string `json:"message"`
+	FaultText      string `json:"faultText"`
+	Icon           string `json:"icon"`
+	IconLabel      string `json:"iconLabel"`
+	IconDetailText string `json:"iconDetailText"`
+
+	OffPeakSchedulesEnabled bool `json:"offPeakSchedulesEnabled"`
+}
+
+// Outlet is the state and settings of a smart plug.
+type Outlet struct {
+	DeviceGID DeviceGID `json:"deviceGid"`
+	LoadGID   int       `json:"loadGid"`
+	// ParentDeviceGID and ParentChannelNum are the monitor channel the plug is on, if any.
+	ParentDeviceGID  DeviceGID `json:"parentDeviceGid,omitempty"`
+	ParentChannelNum string    `json:"parentChannelNum,omitempty"`
+
+	OutletOn bool `json:"outletOn"`
+	// Schedules turn the plug on and off, as set in the app.  They are kept as Emporia sends them.
+	Schedules json.RawMessage `json:"schedules,omitempty"`
 }
 
 type Channel struct {
@@ -236,9 +476,17 @@ var scalePageSize = map[Scale]time.Duration{
 	// Week? Month? Year?
 }
 
+// LookupPageSize returns the maximum interval size that may be fetched with [Client.GetHistoryPage],
+// and whether history can be fetched at this scale at all.
+func (s Scale) LookupPageSize() (time.Duration, bool) {
+	d, ok := scalePageSize[s]
+	return d, ok
+}
+
 // PageSize is the maximum interval size that may be fetched with [Client.GetHistoryPage].
+// It panics for scales that [Scale.LookupPageSize] doesn't support.
 func (s Scale) PageSize() time.Duration {
-	d, ok := scalePageSize[s]
+	d, ok := s.LookupPageSize()
 	if !ok {
 		panic(fmt.Sprintf("Unknown scale page size for scale %q", s))
 	}
@@ -0,0 +1,326 @@
+package vueclient_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/oauth2"
+
+	"sgrankin.dev/vuescrape/vueclient"
+	"sgrankin.dev/vuescrape/vueclient/vuefake"
+)
+
+var now = time.Date(2024, 3, 1, 12, 0, 30, 0, time.UTC)
+
+func newTestClient(t *testing.T) *vueclient.Client {
+	t.Helper()
+	return newFakeClient(t, &vuefake.Server{Now: func() time.Time { return now }})
+}
+
+// newFakeClient returns a client of api.
+func newFakeClient(t *testing.T, api http.Handler) *vueclient.Client {
+	t.Helper()
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	tok := vueclient.NewAtom(&vueclient.Token{
+		Token:   oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)},
+		IDToken: "id",
+	})
+	c := vueclient.NewClient(tok, nil)
+	c.BaseURL, _ = url.Parse(srv.URL)
+	return c
+}
+
+func TestClient_GetDevices(t *testing.T) {
+	c := newTestClient(t)
+	got, err := c.GetDevices()
+	if err != nil {
+		t.Fatalf("GetDevices() error = %v", err)
+	}
+	if diff := cmp.Diff(vuefake.DefaultDevices(), got); diff != "" {
+		t.Errorf("GetDevices() diff (-want+got):\n%s", diff)
+	}
+}
+
+func TestClient_GetDevicesStatus(t *testing.T) {
+	lastSeen := now.Add(-time.Hour)
+	c := newFakeClient(t, &vuefake.Server{
+		Now:     func() time.Time { return now },
+		Offline: map[vueclient.DeviceGID]time.Time{1001: lastSeen},
+	})
+	got, err := c.GetDevicesStatus()
+	if err != nil {
+		t.Fatalf("GetDevicesStatus() error = %v", err)
+	}
+	want := []vueclient.DeviceConnected{
+		{DeviceGID: 1000, Connected: true, OfflineSince: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{DeviceGID: 1001, Connected: false, OfflineSince: lastSeen},
+		{DeviceGID: 1002, Connected: true, OfflineSince: time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if diff := cmp.Diff(want, got.DevicesConnected); diff != "" {
+		t.Errorf("GetDevicesStatus() diff (-want+got):\n%s", diff)
+	}
+}
+
+// TestClient_GetDevicesStatus_Emporia decodes a response shaped like the example in PyEmVue's api_docs.
+func TestClient_GetDevicesStatus_Emporia(t *testing.T) {
+	c := newFakeClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/customers/devices/status" {
+			http.NotFound(w, r)
+			return
+		}
+		http.ServeFile(w, r, "testdata/devices_status.json")
+	}))
+	got, err := c.GetDevicesStatus()
+	if err != nil {
+		t.Fatalf("GetDevicesStatus() error = %v", err)
+	}
+	never := time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)
+	want := &vueclient.DevicesStatus{
+		DevicesConnected: []vueclient.DeviceConnected{
+			{DeviceGID: 123456, Connected: true, OfflineSince: never},
+			{DeviceGID: 234567, Connected: false, OfflineSince: time.Date(2024, 2, 29, 17, 41, 9, 0, time.UTC)},
+			{DeviceGID: 456789, Connected: true, OfflineSince: never},
+		},
+		Outlets: []vueclient.Outlet{{
+			DeviceGID:        234567,
+			LoadGID:          2345670,
+			ParentDeviceGID:  123456,
+			ParentChannelNum: "1,2,3",
+			OutletOn:         true,
+			Schedules:        json.RawMessage(`[]`),
+		}},
+		EVChargers: []vueclient.EVCharger{{
+			DeviceGID:       456789,
+			LoadGID:         4567890,
+			ChargerOn:       false,
+			ChargingRate:    30,
+			MaxChargingRate: 40,
+			BreakerPIN:      "1234",
+			Status:          "Standby",
+			Message:         "Your car is plugged in, but isn't charging. Check your car's charging settings.",
+			Icon:            "CarConnected",
+			IconLabel:       "Connected",
+			IconDetailText:  "Charging is paused.",
+		}},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GetDevicesStatus() diff (-want+got):\n%s", diff)
+	}
+}
+
+func TestClient_Outlets(t *testing.T) {
+	c := newTestClient(t)
+	got, err := c.GetOutlets()
+	if err != nil {
+		t.Fatalf("GetOutlets() error = %v", err)
+	}
+	plug := vueclient.Outlet{
+		DeviceGID:        1001,
+		LoadGID:          10010,
+		ParentDeviceGID:  1000,
+		ParentChannelNum: "1,2,3",
+		OutletOn:         true,
+		Schedules:        json.RawMessage(`[]`),
+	}
+	if diff := cmp.Diff([]vueclient.Outlet{plug}, got); diff != "" {
+		t.Errorf("GetOutlets() diff (-want+got):\n%s", diff)
+	}
+
+	o := got[0]
+	o.OutletOn = false
+	outlet, err := c.SetOutlet(&o)
+	if err != nil {
+		t.Fatalf("SetOutlet() error = %v", err)
+	}
+	if outlet.OutletOn {
+		t.Errorf("SetOutlet() = %+v, want it off", outlet)
+	}
+	got, err = c.GetOutlets()
+	if err != nil {
+		t.Fatalf("GetOutlets() error = %v", err)
+	}
+	plug.OutletOn = false
+	if diff := cmp.Diff([]vueclient.Outlet{plug}, got); diff != "" {
+		t.Errorf("GetOutlets() after SetOutlet() diff (-want+got):\n%s", diff)
+	}
+
+	// Settings missing from the request would be cleared.
+	if _, err := c.SetOutlet(&vueclient.Outlet{DeviceGID: 1001, OutletOn: true}); err == nil {
+		t.Errorf("SetOutlet() without the outlet's settings succeeded, want an error")
+	}
+	if _, err := c.SetOutlet(&vueclient.Outlet{DeviceGID: 1000, OutletOn: true}); err == nil {
+		t.Errorf("SetOutlet() of a monitor succeeded, want an error")
+	}
+}
+
+func TestClient_EVChargers(t *testing.T) {
+	c := newTestClient(t)
+	chargers, err := c.GetEVChargers()
+	if err != nil {
+		t.Fatalf("GetEVChargers() error = %v", err)
+	}
+	if len(chargers) != 1 || chargers[0].DeviceGID != 1002 || !chargers[0].ChargerOn {
+		t.Fatalf("GetEVChargers() = %+v, want charger 1002 on", chargers)
+	}
+
+	ch := chargers[0]
+	ch.ChargerOn = false
+	ch.ChargingRate = 16
+	got, err := c.UpdateEVCharger(&ch)
+	if err != nil {
+		t.Fatalf("UpdateEVCharger() error = %v", err)
+	}
+	if got.ChargerOn || got.ChargingRate != 16 || got.MaxChargingRate != ch.MaxChargingRate || got.Status == chargers[0].Status {
+		t.Errorf("UpdateEVCharger() = %+v, want it paused at 16 amps", got)
+	}
+	chargers, err = c.GetEVChargers()
+	if err != nil {
+		t.Fatalf("GetEVChargers() error = %v", err)
+	}
+	if diff := cmp.Diff([]vueclient.EVCharger{*got}, chargers); diff != "" {
+		t.Errorf("GetEVChargers() after UpdateEVCharger() diff (-want+got):\n%s", diff)
+	}
+
+	ch.ChargingRate = ch.MaxChargingRate + 1
+	if _, err := c.UpdateEVCharger(&ch); err == nil {
+		t.Errorf("UpdateEVCharger() above the maximum rate succeeded, want an error")
+	}
+}
+
+func TestClient_GetHistory(t *testing.T) {
+	c := newTestClient(t)
+	scale := vueclient.Scale1Minute
+	// Spans two pages, and ends with a bucket that is not complete yet.
+	start := now.Add(-1000 * time.Minute)
+	inst, got, err := c.GetHistory(1000, "1", start, now, scale, vueclient.EnergyKWh)
+	if err != nil {
+		t.Fatalf("GetHistory() error = %v", err)
+	}
+	if want := start.Truncate(time.Minute); !inst.Equal(want) {
+		t.Errorf("GetHistory() instant = %v, want %v", inst, want)
+	}
+	var want []*float64
+	for ts := inst; !ts.Add(time.Minute).After(now); ts = ts.Add(time.Minute) {
+		want = append(want, vuefake.Usage(1000, "1", ts, time.Minute))
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("GetHistory() diff (-want+got):\n%s", diff)
+	}
+	gaps := 0
+	for _, v := range got {
+		if v == nil {
+			gaps++
+		}
+	}
+	if gaps == 0 {
+		t.Errorf("GetHistory() returned no gaps in %d samples", len(got))
+	}
+}
+
+func TestClient_GetHistoryPage_PageSize(t *testing.T) {
+	c := newTestClient(t)
+	scale := vueclient.Scale1Minute
+	start := now.Add(-scale.PageSize() - time.Minute)
+	if _, _, err := c.GetHistoryPage(1000, "1", start, now, scale, vueclient.EnergyKWh); err == nil {
+		t.Error("GetHistoryPage() beyond the page size succeeded, want error")
+	}
+}
+
+func TestScale_LookupPageSize(t *testing.T) {
+	for _, tt := range []struct {
+		scale vueclient.Scale
+		want  bool
+	}{
+		{vueclient.Scale1Second, true},
+		{vueclient.Scale1Minute, true},
+		{vueclient.Scale1Hour, true},
+		{vueclient.Scale1Day, false},
+		{vueclient.Scale1Month, false},
+		{"1X", false},
+	} {
+		if page, ok := tt.scale.LookupPageSize(); ok != tt.want || ok && page <= 0 {
+			t.Errorf("%s.LookupPageSize() = %v, %v, want ok = %v", tt.scale, page, ok, tt.want)
+		}
+	}
+}
+
+func TestClient_GetUsage(t *testing.T) {
+	c := newTestClient(t)
+	inst, got, err := c.GetUsage([]vueclient.DeviceGID{1000}, now, vueclient.Scale1Minute, vueclient.EnergyKWh)
+	if err != nil {
+		t.Fatalf("GetUsage() error = %v", err)
+	}
+	if want := now.Truncate(time.Minute).Add(-time.Minute); !inst.Equal(want) {
+		t.Errorf("GetUsage() instant = %v, want %v", inst, want)
+	}
+	if len(got) != 1 || got[0].DeviceGID != 1000 {
+		t.Fatalf("GetUsage() = %+v, want device 1000", got)
+	}
+	usages := got[0].ChannelUsages
+	if len(usages) != len(vuefake.DefaultDevices()[0].Channels) {
+		t.Fatalf("GetUsage() returned %d channels", len(usages))
+	}
+	if nested := usages[0].NestedDevices; len(nested) != 1 || nested[0].DeviceGID != 1001 {
+		t.Errorf("GetUsage() nested devices = %+v, want device 1001", nested)
+	}
+}
+
+// countingTransport counts the requests it makes.
+type countingTransport struct {
+	mu       sync.Mutex
+	requests int
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	t.requests++
+	t.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestNewClientWith(t *testing.T) {
+	idp := newFakeIDP()
+	api := &vuefake.Server{Now: func() time.Time { return now }}
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "" {
+			idp.ServeHTTP(w, r)
+			return
+		}
+		userAgent = r.UserAgent()
+		api.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	base, _ := url.Parse(srv.URL)
+	transport := &countingTransport{}
+	c := vueclient.NewClientWith(vueclient.NewAtom(&vueclient.Token{}), func() (string, string, error) {
+		return "user@example.com", "hunter2", nil
+	}, vueclient.Options{
+		BaseURL:         base,
+		CognitoEndpoint: srv.URL,
+		Transport:       transport,
+		UserAgent:       "test/1.0",
+		Rate:            100,
+	})
+	if _, err := c.GetDevices(); err != nil {
+		t.Fatalf("GetDevices() error = %v", err)
+	}
+	if userAgent != "test/1.0" {
+		t.Errorf("User-Agent = %q, want test/1.0", userAgent)
+	}
+	// Two requests to sign in, and one to the API.
+	if transport.requests != 3 {
+		t.Errorf("Transport made %d requests, want 3", transport.requests)
+	}
+	if c.Throttle.CurrentRate() != 100 {
+		t.Errorf("CurrentRate() = %v, want 100", c.Throttle.CurrentRate())
+	}
+}
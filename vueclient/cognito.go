@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	cognitosrp "github.com/alexrudd/cognito-srp/v4"
@@ -15,24 +16,66 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
 	"golang.org/x/oauth2"
+	"golang.org/x/sync/singleflight"
 )
 
 type Cognito struct {
 	Region   string
 	ClientID string
 	UserPool string // region_guid
+
+	// Endpoint, if set, overrides the URL of the Cognito API, e.g. to use a local stand-in.
+	Endpoint string
+	// IDP, if set, is used instead of a client of the Cognito API.
+	IDP IdentityProvider
+	// Transport, if set, makes the HTTP requests to the Cognito API.
+	Transport http.RoundTripper
+
+	// ChallengeFunc answers the challenges that can follow the password:
+	// the code for SOFTWARE_TOKEN_MFA and SMS_MFA, or the new password for NEW_PASSWORD_REQUIRED.
+	// If nil, sign in fails when a challenge is issued.
+	ChallengeFunc func(*Challenge) (string, error)
+}
+
+// Challenge is a step of signing in that needs an answer from the user.
+type Challenge struct {
+	Name     types.ChallengeNameType
+	Username string
+	// Parameters are sent with the challenge, e.g. CODE_DELIVERY_DESTINATION for SMS_MFA.
+	Parameters map[string]string
+}
+
+// challengeAnswers maps supported challenges to the response that carries the answer.
+var challengeAnswers = map[types.ChallengeNameType]string{
+	types.ChallengeNameTypeSoftwareTokenMfa:    "SOFTWARE_TOKEN_MFA_CODE",
+	types.ChallengeNameTypeSmsMfa:              "SMS_MFA_CODE",
+	types.ChallengeNameTypeNewPasswordRequired: "NEW_PASSWORD",
+}
+
+// IdentityProvider is the subset of the Cognito user pools API used to authenticate.
+// It is implemented by *cognitoidentityprovider.Client.
+type IdentityProvider interface {
+	InitiateAuth(context.Context, *cognitoidentityprovider.InitiateAuthInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.InitiateAuthOutput, error)
+	RespondToAuthChallenge(context.Context, *cognitoidentityprovider.RespondToAuthChallengeInput, ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.RespondToAuthChallengeOutput, error)
 }
 
+var _ IdentityProvider = (*cognitoidentityprovider.Client)(nil)
+
 type Token struct {
 	oauth2.Token
 
 	// IDToken is a JWT that contains identity claims of the user.
 	IDToken string `json:"id_token,omitempty"`
+	// Issued is when the token was received.  It is zero for tokens stored by older versions.
+	Issued time.Time `json:"issued,omitempty"`
 }
 
 func (c *Cognito) Auth(ctx context.Context, username, password string) (*Token, error) {
 	now := time.Now() // For calculating expiration once we authd.
-	idp := c.idp(ctx)
+	idp, err := c.idp(ctx)
+	if err != nil {
+		return nil, err
+	}
 	csrp, err := cognitosrp.NewCognitoSRP(username, password, c.UserPool, c.ClientID, nil)
 	if err != nil {
 		return nil, fmt.Errorf("srp: %w", err)
@@ -49,6 +92,8 @@ func (c *Cognito) Auth(ctx context.Context, username, password string) (*Token,
 		return nil, fmt.Errorf("unsupported challenge type: %s", authResp.ChallengeName)
 	}
 	challengeResponses, _ := csrp.PasswordVerifierChallenge(authResp.ChallengeParameters, time.Now())
+	// Later challenges are answered for the user's internal name.
+	internalUsername := challengeResponses["USERNAME"]
 
 	challengeResp, err := idp.RespondToAuthChallenge(ctx, &cognitoidentityprovider.RespondToAuthChallengeInput{
 		ChallengeName:      types.ChallengeNameTypePasswordVerifier,
@@ -58,12 +103,39 @@ func (c *Cognito) Auth(ctx context.Context, username, password string) (*Token,
 	if err != nil {
 		return nil, fmt.Errorf("challenge response: %w", err)
 	}
-	return mkToken(challengeResp.AuthenticationResult, now, ""), nil
+	for challengeResp.AuthenticationResult == nil {
+		name := challengeResp.ChallengeName
+		key, ok := challengeAnswers[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported challenge type: %s", name)
+		}
+		if c.ChallengeFunc == nil {
+			return nil, fmt.Errorf("challenge %s needs an answer, but ChallengeFunc is not set", name)
+		}
+		answer, err := c.ChallengeFunc(&Challenge{Name: name, Username: username, Parameters: challengeResp.ChallengeParameters})
+		if err != nil {
+			return nil, fmt.Errorf("challenge %s: %w", name, err)
+		}
+		now = time.Now() // Answering may have taken a while.
+		challengeResp, err = idp.RespondToAuthChallenge(ctx, &cognitoidentityprovider.RespondToAuthChallengeInput{
+			ChallengeName:      name,
+			ChallengeResponses: map[string]string{"USERNAME": internalUsername, key: answer},
+			ClientId:           aws.String(c.ClientID),
+			Session:            challengeResp.Session,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("challenge %s response: %w", name, err)
+		}
+	}
+	return mkToken(challengeResp.AuthenticationResult, now, "")
 }
 
 func (c *Cognito) Refresh(ctx context.Context, refreshToken string) (*Token, error) {
 	now := time.Now() // For calculating expiration once we authd.
-	idp := c.idp(ctx)
+	idp, err := c.idp(ctx)
+	if err != nil {
+		return nil, err
+	}
 	authResp, err := idp.InitiateAuth(ctx, &cognitoidentityprovider.InitiateAuthInput{
 		AuthFlow:       types.AuthFlowTypeRefreshToken,
 		ClientId:       aws.String(c.ClientID),
@@ -72,24 +144,35 @@ func (c *Cognito) Refresh(ctx context.Context, refreshToken string) (*Token, err
 	if err != nil {
 		return nil, fmt.Errorf("auth: %w", err)
 	}
-	log.Printf("%+v", authResp)
-	log.Printf("%+v", authResp.AuthenticationResult)
-	log.Printf("%+v", authResp.AuthenticationResult)
-	log.Printf("%+v", authResp.ChallengeName)
-	log.Printf("%+v", authResp.ChallengeParameters)
-	return mkToken(authResp.AuthenticationResult, now, refreshToken), nil
+	return mkToken(authResp.AuthenticationResult, now, refreshToken)
 }
 
-func (c *Cognito) idp(ctx context.Context) *cognitoidentityprovider.Client {
-	cfg, _ := config.LoadDefaultConfig(
+func (c *Cognito) idp(ctx context.Context) (IdentityProvider, error) {
+	if c.IDP != nil {
+		return c.IDP, nil
+	}
+	cfg, err := config.LoadDefaultConfig(
 		ctx,
 		config.WithRegion(c.Region),
 		config.WithCredentialsProvider(aws.AnonymousCredentials{}),
 	)
-	return cognitoidentityprovider.NewFromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("aws config: %w", err)
+	}
+	return cognitoidentityprovider.NewFromConfig(cfg, func(o *cognitoidentityprovider.Options) {
+		if c.Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.Endpoint)
+		}
+		if c.Transport != nil {
+			o.HTTPClient = &http.Client{Transport: c.Transport}
+		}
+	}), nil
 }
 
-func mkToken(auth *types.AuthenticationResultType, now time.Time, refreshToken string) *Token {
+func mkToken(auth *types.AuthenticationResultType, now time.Time, refreshToken string) (*Token, error) {
+	if auth == nil {
+		return nil, errors.New("no authentication result")
+	}
 	tok := &Token{
 		Token: oauth2.Token{
 			AccessToken:  *auth.AccessToken,
@@ -98,12 +181,13 @@ func mkToken(auth *types.AuthenticationResultType, now time.Time, refreshToken s
 			Expiry:       now.Add(time.Duration(auth.ExpiresIn) * time.Second),
 		},
 		IDToken: *auth.IdToken,
+		Issued:  now,
 	}
 	if auth.RefreshToken != nil {
 		// Auth results after a refresh don't carry the refresh token. ಠ_ಠ
 		tok.RefreshToken = *auth.RefreshToken
 	}
-	return tok
+	return tok, nil
 }
 
 type CognitoTokenSource struct {
@@ -115,25 +199,253 @@ type CognitoTokenSource struct {
 	// AuthFunc is used to get a username & password if initial auth is needed.
 	AuthFunc func() (string, string, error)
 
-	mu sync.Mutex
+	// Store, if set, holds the token shared with other processes.
+	// Expired tokens are renewed within Store.Update, using the stored token if another process already renewed it.
+	// A renewed token that can't be stored is used anyway, and saving it is retried; see SaveErr.
+	Store TokenStore
+
+	// RefreshMargin, if positive, is how long before expiry tokens are renewed.
+	// While a token is within the margin, Token keeps returning it and renews it in the background.
+	RefreshMargin time.Duration
+
+	renewing singleflight.Group // Renewals shared by concurrent callers.
+	earlyAt  atomic.Int64       // Unix nanoseconds before which not to renew early, after a failure.
+
+	mu          sync.Mutex // Held while renewing.
+	unsaved     *Token     // Renewed token that Store failed to save, if any.
+	saveErr     error      // Why unsaved wasn't saved.
+	saveRetryAt time.Time  // When to try saving unsaved again.
+}
+
+const (
+	// saveRetryInterval is how long to wait between attempts to save a token after Store fails.
+	saveRetryInterval = time.Minute
+	// renewRetryInterval is how long to wait between attempts to renew a token that is still valid.
+	renewRetryInterval = 30 * time.Second
+)
+
+// TokenStore is persistent token storage shared between processes.
+type TokenStore interface {
+	// Update calls f with the stored token and stores the token f returns,
+	// excluding other processes from updating the token meanwhile.
+	Update(f func(stored *Token) (*Token, error)) (*Token, error)
 }
 
+// Token returns the current token, renewing it if it has expired.
+// Concurrent callers share a renewal.
 func (c *CognitoTokenSource) Token() (*Token, error) {
+	tok := c.Tok.Load()
+	if c.fresh(tok) {
+		c.retrySave()
+		return tok, nil
+	}
+	if tok.Valid() {
+		if time.Now().UnixNano() >= c.earlyAt.Load() {
+			// Callers don't wait for the result, which is stored in Tok.
+			c.renewing.DoChan("", c.renewEarly)
+		}
+		return tok, nil
+	}
+	v, err, _ := c.renewing.Do("", c.renewNow)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Token), nil
+}
+
+// fresh reports whether tok is valid and not yet due to be renewed.
+func (c *CognitoTokenSource) fresh(tok *Token) bool {
+	if !tok.Valid() {
+		return false
+	}
+	return c.RefreshMargin <= 0 || tok.Expiry.IsZero() || time.Until(tok.Expiry) > c.RefreshMargin
+}
+
+// renewEarly renews a token that is still valid, logging failures, which are retried later.
+func (c *CognitoTokenSource) renewEarly() (any, error) {
+	tok, err := c.renewNow()
+	if err != nil {
+		log.Printf("renewing token before it expires: %v", err)
+	}
+	if err != nil || !c.fresh(tok.(*Token)) {
+		// Don't try again on every request.
+		c.earlyAt.Store(time.Now().Add(renewRetryInterval).UnixNano())
+	}
+	return tok, err
+}
+
+// renewNow renews the token, unless it was renewed meanwhile, and stores it in Tok.
+func (c *CognitoTokenSource) renewNow() (any, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	tok := c.Tok.Load()
-	if tok.Valid() {
+	cur := c.Tok.Load()
+	if c.fresh(cur) {
+		return cur, nil
+	}
+	var tok *Token
+	var err error
+	if c.Store != nil {
+		tok, err = c.renewStored(cur)
+	} else {
+		tok, err = c.renew(cur)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tok.IDToken == cur.IDToken && tok.Expiry.Equal(cur.Expiry) {
+		// Unchanged; don't wake watchers.
+		return cur, nil
+	}
+	c.Tok.Reset(tok)
+	return tok, nil
+}
+
+// KeepFresh renews the token RefreshMargin before it expires, until ctx is done,
+// so that requests don't wait for renewals.  Failures are logged and retried.
+// A token that has not been signed in yet is left for Token to get.
+func (c *CognitoTokenSource) KeepFresh(ctx context.Context) {
+	updates, cancel := c.Tok.Subscribe()
+	defer cancel()
+	retryAt := time.Time{}
+	for {
+		tok := c.Tok.Load()
+		var timer *time.Timer
+		var due <-chan time.Time
+		if tok.AccessToken != "" && !tok.Expiry.IsZero() {
+			timer = time.NewTimer(max(time.Until(tok.Expiry.Add(-c.RefreshMargin)), time.Until(retryAt)))
+			due = timer.C
+		}
+		renew := false
+		select {
+		case <-ctx.Done():
+		case <-updates:
+		case <-due:
+			renew = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !renew {
+			continue
+		}
+		v, err, _ := c.renewing.Do("", c.renewNow)
+		if err != nil {
+			log.Printf("renewing token before it expires: %v", err)
+		}
+		if err != nil || !c.fresh(v.(*Token)) {
+			// Renewal failed, or gave a token that is already due; wait rather than loop.
+			retryAt = time.Now().Add(renewRetryInterval)
+		}
+	}
+}
+
+// retrySave saves a token that Store failed to save earlier, if it is time to retry.
+// It doesn't wait for a renewal in progress, which saves the token itself.
+func (c *CognitoTokenSource) retrySave() {
+	if !c.mu.TryLock() {
+		return
+	}
+	defer c.mu.Unlock()
+	if c.unsaved != nil && !time.Now().Before(c.saveRetryAt) {
+		c.save()
+	}
+}
+
+// SaveErr returns the error from saving the current token to Store, if it couldn't be saved.
+// The token is still used, but the next process to start may need to sign in again.
+func (c *CognitoTokenSource) SaveErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.unsaved == nil {
+		return nil
+	}
+	return c.saveErr
+}
+
+// renewStored renews tok within Store.Update.
+// If the store fails, the token is renewed regardless and kept unsaved.
+func (c *CognitoTokenSource) renewStored(tok *Token) (*Token, error) {
+	var renewed *Token
+	called, shared := false, false
+	stored, err := c.Store.Update(func(stored *Token) (*Token, error) {
+		called = true
+		if c.fresh(stored) {
+			// Another process renewed it.
+			renewed, shared = stored, true
+			return stored, nil
+		}
+		if stored.RefreshToken == "" || c.unsaved != nil {
+			// The store has no token, or an older one than ours.
+			stored = tok
+		}
+		var err error
+		renewed, err = c.renew(stored)
+		return renewed, err
+	})
+	switch {
+	case err == nil:
+		c.unsaved = nil
+		return stored, nil
+	case !called:
+		// The store couldn't be read.
+		var renewErr error
+		if renewed, renewErr = c.renew(tok); renewErr != nil {
+			return nil, renewErr
+		}
+	case renewed == nil:
+		// Renewing failed.
+		return nil, err
+	case shared:
+		// It is already stored.
+		return renewed, nil
+	}
+	c.keepUnsaved(renewed, err)
+	return renewed, nil
+}
+
+// save retries saving the unsaved token.
+func (c *CognitoTokenSource) save() {
+	tok := c.unsaved
+	_, err := c.Store.Update(func(stored *Token) (*Token, error) {
+		if stored.Valid() && stored.Expiry.After(tok.Expiry) {
+			// Another process stored a newer token.
+			return stored, nil
+		}
 		return tok, nil
+	})
+	if err != nil {
+		c.keepUnsaved(tok, err)
+		return
+	}
+	log.Printf("saved the renewed token after an earlier failure")
+	c.unsaved = nil
+}
+
+func (c *CognitoTokenSource) keepUnsaved(tok *Token, err error) {
+	if c.unsaved == nil {
+		log.Printf("warning: could not save the renewed token, so the next run may need to sign in again; retrying: %v", err)
 	}
+	c.unsaved, c.saveErr, c.saveRetryAt = tok, err, time.Now().Add(saveRetryInterval)
+}
+
+// renew gets a new token, refreshing tok if possible.
+func (c *CognitoTokenSource) renew(tok *Token) (*Token, error) {
 	ctx := context.Background()
 	if tok.RefreshToken != "" {
 		tok, err := c.Cognito.Refresh(ctx, tok.RefreshToken)
-		if err != nil {
+		if err == nil {
+			return tok, nil
+		}
+		// A revoked or expired refresh token can be replaced by signing in again.
+		var notAuthorized *types.NotAuthorizedException
+		if !errors.As(err, &notAuthorized) || c.AuthFunc == nil {
 			return nil, fmt.Errorf("refresh: %w", err)
 		}
-		c.Tok.Reset(tok)
-		return tok, nil
+		log.Printf("refresh token rejected, signing in again: %v", err)
 	}
 
 	if c.AuthFunc == nil {
@@ -147,7 +459,6 @@ func (c *CognitoTokenSource) Token() (*Token, error) {
 	if err != nil {
 		return nil, fmt.Errorf("auth: %w", err)
 	}
-	c.Tok.Reset(tok)
 	return tok, nil
 }
 
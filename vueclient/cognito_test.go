@@ -0,0 +1,506 @@
+package vueclient_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/smithy-go"
+	"github.com/google/go-cmp/cmp"
+	"golang.org/x/oauth2"
+
+	"sgrankin.dev/vuescrape/vueclient"
+	"sgrankin.dev/vuescrape/vueclient/cognitofake"
+)
+
+func newFakeIDP() *cognitofake.Server {
+	def := vueclient.DefaultCognito()
+	return &cognitofake.Server{
+		UserPool: def.UserPool,
+		ClientID: def.ClientID,
+		Users:    map[string]string{"user@example.com": "hunter2"},
+	}
+}
+
+func newTokenSource(idp vueclient.IdentityProvider, tok *vueclient.Token, password string) (*vueclient.CognitoTokenSource, *int) {
+	cognito := vueclient.DefaultCognito()
+	cognito.IDP = idp
+	logins := new(int)
+	return &vueclient.CognitoTokenSource{
+		Cognito: cognito,
+		Tok:     vueclient.NewAtom(tok),
+		AuthFunc: func() (string, string, error) {
+			*logins++
+			return "user@example.com", password, nil
+		},
+	}, logins
+}
+
+func TestCognito_Auth(t *testing.T) {
+	idp := newFakeIDP()
+	for _, tt := range []struct {
+		name     string
+		user     string
+		password string
+		wantErr  string // Error code.
+	}{
+		{"ok", "user@example.com", "hunter2", ""},
+		{"wrong password", "user@example.com", "hunter3", "NotAuthorizedException"},
+		{"unknown user", "nobody@example.com", "hunter2", "UserNotFoundException"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cognito := vueclient.DefaultCognito()
+			cognito.IDP = idp
+			tok, err := cognito.Auth(context.Background(), tt.user, tt.password)
+			if tt.wantErr != "" {
+				var apiErr smithy.APIError
+				if !errors.As(err, &apiErr) || apiErr.ErrorCode() != tt.wantErr {
+					t.Fatalf("Auth() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Auth() error = %v", err)
+			}
+			if tok.IDToken == "" || tok.RefreshToken == "" || !tok.Valid() {
+				t.Errorf("Auth() = %+v, want a valid token with ID and refresh tokens", tok)
+			}
+		})
+	}
+}
+
+func TestCognito_HTTP(t *testing.T) {
+	srv := httptest.NewServer(newFakeIDP())
+	t.Cleanup(srv.Close)
+	cognito := vueclient.DefaultCognito()
+	cognito.Endpoint = srv.URL
+	ctx := context.Background()
+
+	tok, err := cognito.Auth(ctx, "user@example.com", "hunter2")
+	if err != nil {
+		t.Fatalf("Auth() error = %v", err)
+	}
+	refreshed, err := cognito.Refresh(ctx, tok.RefreshToken)
+	if err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if refreshed.IDToken == tok.IDToken || refreshed.RefreshToken != tok.RefreshToken {
+		t.Errorf("Refresh() = %+v, want a new ID token and the old refresh token %q", refreshed, tok.RefreshToken)
+	}
+	var notAuthorized *types.NotAuthorizedException
+	if _, err := cognito.Refresh(ctx, "bogus"); !errors.As(err, &notAuthorized) {
+		t.Errorf("Refresh(bogus) error = %v, want NotAuthorizedException", err)
+	}
+}
+
+func TestCognitoTokenSource(t *testing.T) {
+	expired := time.Now().Add(-time.Minute)
+	signIn := func(t *testing.T, idp *cognitofake.Server) *vueclient.Token {
+		t.Helper()
+		src, _ := newTokenSource(idp, &vueclient.Token{}, "hunter2")
+		tok, err := src.Token()
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		return tok
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		tok := &vueclient.Token{Token: oauth2.Token{AccessToken: "a", Expiry: time.Now().Add(time.Hour)}, IDToken: "id"}
+		src, logins := newTokenSource(newFakeIDP(), tok, "hunter2")
+		if got, err := src.Token(); err != nil || got != tok || *logins != 0 {
+			t.Errorf("Token() = %v, %v after %d logins, want the stored token", got, err, *logins)
+		}
+	})
+	t.Run("expired", func(t *testing.T) {
+		idp := newFakeIDP()
+		tok := signIn(t, idp)
+		old := *tok
+		tok.Expiry = expired
+		src, logins := newTokenSource(idp, tok, "hunter2")
+		got, err := src.Token()
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if *logins != 0 {
+			t.Errorf("Token() signed in %d times, want a refresh", *logins)
+		}
+		if got.IDToken == old.IDToken || got.RefreshToken != old.RefreshToken || !got.Valid() {
+			t.Errorf("Token() = %+v, want a new valid ID token keeping refresh token %q", got, old.RefreshToken)
+		}
+		if src.Tok.Load() != got {
+			t.Errorf("Token() did not store the refreshed token")
+		}
+	})
+	t.Run("no refresh token", func(t *testing.T) {
+		src, logins := newTokenSource(newFakeIDP(), &vueclient.Token{Token: oauth2.Token{Expiry: expired}}, "hunter2")
+		got, err := src.Token()
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if *logins != 1 || got.RefreshToken == "" {
+			t.Errorf("Token() = %+v after %d logins, want a new sign in", got, *logins)
+		}
+	})
+	t.Run("no refresh token or AuthFunc", func(t *testing.T) {
+		src, _ := newTokenSource(newFakeIDP(), &vueclient.Token{}, "hunter2")
+		src.AuthFunc = nil
+		if _, err := src.Token(); err == nil {
+			t.Errorf("Token() succeeded, want an error")
+		}
+	})
+	t.Run("revoked refresh token", func(t *testing.T) {
+		idp := newFakeIDP()
+		tok := signIn(t, idp)
+		tok.Expiry = expired
+		idp.RevokeRefreshTokens()
+		src, logins := newTokenSource(idp, tok, "hunter2")
+		got, err := src.Token()
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if *logins != 1 || got.RefreshToken == tok.RefreshToken {
+			t.Errorf("Token() = %+v after %d logins, want a new sign in", got, *logins)
+		}
+	})
+	t.Run("wrong password", func(t *testing.T) {
+		src, _ := newTokenSource(newFakeIDP(), &vueclient.Token{}, "hunter3")
+		var notAuthorized *types.NotAuthorizedException
+		if _, err := src.Token(); !errors.As(err, &notAuthorized) {
+			t.Errorf("Token() error = %v, want NotAuthorizedException", err)
+		}
+		if got := src.Tok.Load(); got.AccessToken != "" {
+			t.Errorf("Token() stored %+v after failing", got)
+		}
+	})
+}
+
+func TestCognito_Challenges(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		idp     func(*cognitofake.Server)
+		answers map[types.ChallengeNameType]string
+		want    []types.ChallengeNameType
+		wantErr bool
+	}{
+		{
+			name:    "totp",
+			idp:     func(s *cognitofake.Server) { s.MFA = map[string]string{"user@example.com": "123456"} },
+			answers: map[types.ChallengeNameType]string{types.ChallengeNameTypeSoftwareTokenMfa: "123456"},
+			want:    []types.ChallengeNameType{types.ChallengeNameTypeSoftwareTokenMfa},
+		},
+		{
+			name: "sms",
+			idp: func(s *cognitofake.Server) {
+				s.MFA = map[string]string{"user@example.com": "654321"}
+				s.MFAType = types.ChallengeNameTypeSmsMfa
+			},
+			answers: map[types.ChallengeNameType]string{types.ChallengeNameTypeSmsMfa: "654321"},
+			want:    []types.ChallengeNameType{types.ChallengeNameTypeSmsMfa},
+		},
+		{
+			name: "new password then totp",
+			idp: func(s *cognitofake.Server) {
+				s.PasswordReset = map[string]bool{"user@example.com": true}
+				s.MFA = map[string]string{"user@example.com": "123456"}
+			},
+			answers: map[types.ChallengeNameType]string{
+				types.ChallengeNameTypeNewPasswordRequired: "correct horse",
+				types.ChallengeNameTypeSoftwareTokenMfa:    "123456",
+			},
+			want: []types.ChallengeNameType{types.ChallengeNameTypeNewPasswordRequired, types.ChallengeNameTypeSoftwareTokenMfa},
+		},
+		{
+			name:    "wrong code",
+			idp:     func(s *cognitofake.Server) { s.MFA = map[string]string{"user@example.com": "123456"} },
+			answers: map[types.ChallengeNameType]string{types.ChallengeNameTypeSoftwareTokenMfa: "000000"},
+			want:    []types.ChallengeNameType{types.ChallengeNameTypeSoftwareTokenMfa},
+			wantErr: true,
+		},
+		{
+			name:    "no answer",
+			idp:     func(s *cognitofake.Server) { s.MFA = map[string]string{"user@example.com": "123456"} },
+			want:    []types.ChallengeNameType{types.ChallengeNameTypeSoftwareTokenMfa},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			idp := newFakeIDP()
+			tt.idp(idp)
+			cognito := vueclient.DefaultCognito()
+			cognito.IDP = idp
+			var got []types.ChallengeNameType
+			cognito.ChallengeFunc = func(c *vueclient.Challenge) (string, error) {
+				got = append(got, c.Name)
+				if answer, ok := tt.answers[c.Name]; ok {
+					return answer, nil
+				}
+				return "", errors.New("no answer")
+			}
+			tok, err := cognito.Auth(context.Background(), "user@example.com", "hunter2")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Auth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("Auth() challenges diff (-want+got):\n%s", diff)
+			}
+			if err == nil && !tok.Valid() {
+				t.Errorf("Auth() = %+v, want a valid token", tok)
+			}
+		})
+	}
+
+	t.Run("no ChallengeFunc", func(t *testing.T) {
+		idp := newFakeIDP()
+		idp.PasswordReset = map[string]bool{"user@example.com": true}
+		cognito := vueclient.DefaultCognito()
+		cognito.IDP = idp
+		if _, err := cognito.Auth(context.Background(), "user@example.com", "hunter2"); err == nil {
+			t.Errorf("Auth() succeeded, want an error")
+		}
+	})
+}
+
+// memStore is a TokenStore shared by token sources, as if by several processes.
+type memStore struct {
+	mu      sync.Mutex
+	tok     *vueclient.Token
+	updates int
+
+	readErr, saveErr error // Injected failures.
+}
+
+func (s *memStore) Update(f func(*vueclient.Token) (*vueclient.Token, error)) (*vueclient.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.updates++
+	if s.readErr != nil {
+		return nil, s.readErr
+	}
+	tok, err := f(s.tok)
+	if err != nil {
+		return nil, err
+	}
+	if s.saveErr != nil {
+		return nil, s.saveErr
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+func TestCognitoTokenSource_Store(t *testing.T) {
+	idp := newFakeIDP()
+	cognito := vueclient.DefaultCognito()
+	cognito.IDP = idp
+	tok, err := cognito.Auth(context.Background(), "user@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok.Expiry = time.Now().Add(-time.Minute)
+	store := &memStore{tok: tok}
+
+	src1, logins1 := newTokenSource(idp, tok, "hunter2")
+	src1.Store = store
+	src2, logins2 := newTokenSource(idp, tok, "hunter2")
+	src2.Store = store
+	got1, err := src1.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	got2, err := src2.Token()
+	if err != nil {
+		t.Fatalf("Token() error = %v", err)
+	}
+	if got1.IDToken == tok.IDToken || got2 != got1 || store.tok != got1 {
+		t.Errorf("Token() = %+v and %+v, want the stored token renewed once and shared", got1, got2)
+	}
+	if *logins1+*logins2 != 0 {
+		t.Errorf("Token() signed in %d times, want a refresh", *logins1+*logins2)
+	}
+}
+
+func TestCognitoTokenSource_StoreFailure(t *testing.T) {
+	expired := &vueclient.Token{Token: oauth2.Token{Expiry: time.Now().Add(-time.Minute)}}
+	for _, tt := range []struct {
+		name  string
+		store *memStore
+	}{
+		{"save", &memStore{tok: expired, saveErr: errors.New("disk full")}},
+		{"read", &memStore{tok: expired, readErr: errors.New("corrupt")}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			src, logins := newTokenSource(newFakeIDP(), expired, "hunter2")
+			src.Store = tt.store
+			got, err := src.Token()
+			if err != nil {
+				t.Fatalf("Token() error = %v, want the unsaved token", err)
+			}
+			if !got.Valid() || src.Tok.Load() != got {
+				t.Errorf("Token() = %+v, want a valid token kept in memory", got)
+			}
+			if src.SaveErr() == nil {
+				t.Errorf("SaveErr() = nil after the store failed")
+			}
+
+			// Once the store recovers, the next renewal saves the token, refreshing it rather than signing in again.
+			tt.store.readErr, tt.store.saveErr = nil, nil
+			old := *got
+			old.Expiry = expired.Expiry
+			src.Tok.Reset(&old)
+			got, err = src.Token()
+			if err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+			if err := src.SaveErr(); err != nil {
+				t.Errorf("SaveErr() = %v after the store recovered", err)
+			}
+			if tt.store.tok != got || got.RefreshToken != old.RefreshToken || *logins != 1 {
+				t.Errorf("Token() = %+v after %d logins, want a refreshed token saved to the store", got, *logins)
+			}
+		})
+	}
+}
+
+// countingIDP counts refreshes.
+type countingIDP struct {
+	*cognitofake.Server
+	refreshes atomic.Int32
+}
+
+func (c *countingIDP) InitiateAuth(ctx context.Context, in *cognitoidentityprovider.InitiateAuthInput, opts ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.InitiateAuthOutput, error) {
+	if in.AuthFlow == types.AuthFlowTypeRefreshTokenAuth || in.AuthFlow == types.AuthFlowTypeRefreshToken {
+		c.refreshes.Add(1)
+	}
+	return c.Server.InitiateAuth(ctx, in, opts...)
+}
+
+// signedIn returns a fake identity provider and a token signed in to it, expiring in ttl.
+func signedIn(t *testing.T, ttl time.Duration) (*countingIDP, *vueclient.Token) {
+	t.Helper()
+	idp := &countingIDP{Server: newFakeIDP()}
+	idp.TokenTTL = ttl
+	cognito := vueclient.DefaultCognito()
+	cognito.IDP = idp
+	tok, err := cognito.Auth(context.Background(), "user@example.com", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.TokenTTL = time.Hour
+	return idp, tok
+}
+
+func TestCognitoTokenSource_Concurrent(t *testing.T) {
+	idp, tok := signedIn(t, time.Hour)
+	tok.Expiry = time.Now().Add(-time.Minute)
+	src, _ := newTokenSource(idp, tok, "hunter2")
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, err := src.Token(); err != nil || !got.Valid() {
+				t.Errorf("Token() = %+v, %v, want a valid token", got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := idp.refreshes.Load(); n != 1 {
+		t.Errorf("Token() refreshed %d times, want once", n)
+	}
+}
+
+// withStore runs f with a token source without a Store, and with one holding the same token.
+func withStore(t *testing.T, idp vueclient.IdentityProvider, tok *vueclient.Token, f func(t *testing.T, src *vueclient.CognitoTokenSource, store *memStore, logins *int)) {
+	t.Run("memory", func(t *testing.T) {
+		src, logins := newTokenSource(idp, tok, "hunter2")
+		f(t, src, nil, logins)
+	})
+	t.Run("store", func(t *testing.T) {
+		src, logins := newTokenSource(idp, tok, "hunter2")
+		store := &memStore{tok: tok}
+		src.Store = store
+		f(t, src, store, logins)
+	})
+}
+
+func TestCognitoTokenSource_RefreshMargin(t *testing.T) {
+	idp, tok := signedIn(t, 2*time.Minute)
+	withStore(t, idp, tok, func(t *testing.T, src *vueclient.CognitoTokenSource, store *memStore, logins *int) {
+		idp.refreshes.Store(0)
+		src.RefreshMargin = 5 * time.Minute
+		updates, cancel := src.Tok.Subscribe()
+		defer cancel()
+
+		if got, err := src.Token(); err != nil || got != tok {
+			t.Fatalf("Token() = %+v, %v, want the current token while it is renewed", got, err)
+		}
+		select {
+		case got := <-updates:
+			if !got.Expiry.After(tok.Expiry) || got.RefreshToken != tok.RefreshToken || *logins != 0 {
+				t.Errorf("renewed token = %+v after %d logins, want a refreshed token expiring later", got, *logins)
+			}
+			if store != nil && store.tok != got {
+				t.Errorf("renewed token was not stored")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("token was not renewed in the background")
+		}
+		// The renewed token is fresh, so it is used without renewing again.
+		for range 10 {
+			if _, err := src.Token(); err != nil {
+				t.Fatalf("Token() error = %v", err)
+			}
+		}
+		if n := idp.refreshes.Load(); n != 1 {
+			t.Errorf("Token() refreshed %d times, want once", n)
+		}
+	})
+}
+
+func TestCognitoTokenSource_KeepFresh(t *testing.T) {
+	idp, tok := signedIn(t, time.Hour)
+	idp.TokenTTL = 2 * time.Hour // Renewed tokens are not due again.
+	withStore(t, idp, tok, func(t *testing.T, src *vueclient.CognitoTokenSource, store *memStore, _ *int) {
+		idp.refreshes.Store(0)
+		// Due for renewal shortly.
+		src.RefreshMargin = time.Until(tok.Expiry) - 100*time.Millisecond
+		updates, cancel := src.Tok.Subscribe()
+		defer cancel()
+
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			src.KeepFresh(ctx)
+		}()
+		select {
+		case got := <-updates:
+			if !got.Expiry.After(tok.Expiry) {
+				t.Errorf("renewed token = %+v, want one expiring later", got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("KeepFresh did not renew the token")
+		}
+		// Give KeepFresh time to spin, if it would.
+		time.Sleep(100 * time.Millisecond)
+		stop()
+		<-done
+		if n := idp.refreshes.Load(); n != 1 {
+			t.Errorf("KeepFresh refreshed %d times, want once", n)
+		}
+		if store != nil {
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			if store.updates != 1 {
+				t.Errorf("KeepFresh updated the store %d times, want once", store.updates)
+			}
+		}
+	})
+}
@@ -0,0 +1,363 @@
+// Package cognitofake implements a fake Cognito user pool for testing authentication offline.
+//
+// It supports the flows used by [vueclient.Cognito]: USER_SRP_AUTH followed by the PASSWORD_VERIFIER challenge
+// and optionally NEW_PASSWORD_REQUIRED and MFA challenges, and REFRESH_TOKEN_AUTH.
+// A [Server] can be used in-process as a [vueclient.IdentityProvider],
+// or over HTTP with the AWS JSON protocol by pointing [vueclient.Cognito.Endpoint] at it.
+// Issued tokens are random strings, not JWTs.
+package cognitofake
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/aws/smithy-go"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// Server is a fake Cognito user pool.
+type Server struct {
+	// UserPool is the pool ID, as region_name.
+	UserPool string
+	// ClientID is the only app client accepted.
+	ClientID string
+	// Users maps user names to passwords.
+	Users map[string]string
+	// PasswordReset lists users that must choose a new password when they next sign in.
+	PasswordReset map[string]bool
+	// MFA maps user names to the code they must give after their password.
+	// Codes are fixed rather than time based.
+	MFA map[string]string
+	// MFAType is the challenge used for MFA codes: SOFTWARE_TOKEN_MFA (the default) or SMS_MFA.
+	MFAType types.ChallengeNameType
+	// TokenTTL is the lifetime of issued access and ID tokens.
+	// If zero, an hour is used.
+	TokenTTL time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*srpSession // By secret block.
+	pending  map[string]string      // Sessions of users with challenges left, to their names.
+	refresh  map[string]bool        // Valid refresh tokens.
+}
+
+// srpSession is the server side of an SRP exchange awaiting the PASSWORD_VERIFIER response.
+type srpSession struct {
+	username string
+	key      []byte // The derived password authentication key.
+}
+
+// RevokeRefreshTokens invalidates every refresh token issued so far, as if the user signed out globally.
+func (s *Server) RevokeRefreshTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refresh = nil
+}
+
+// InitiateAuth implements [vueclient.IdentityProvider].
+func (s *Server) InitiateAuth(_ context.Context, in *cognitoidentityprovider.InitiateAuthInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.InitiateAuthOutput, error) {
+	if aws.ToString(in.ClientId) != s.ClientID {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("User pool client " + aws.ToString(in.ClientId) + " does not exist.")}
+	}
+	switch in.AuthFlow {
+	case types.AuthFlowTypeUserSrpAuth:
+		return s.startSRP(in.AuthParameters)
+	case types.AuthFlowTypeRefreshToken, types.AuthFlowTypeRefreshTokenAuth:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if !s.refresh[in.AuthParameters["REFRESH_TOKEN"]] {
+			return nil, &types.NotAuthorizedException{Message: aws.String("Invalid Refresh Token")}
+		}
+		// Like Cognito, refreshing does not issue a new refresh token.
+		return &cognitoidentityprovider.InitiateAuthOutput{AuthenticationResult: s.issue(false)}, nil
+	default:
+		return nil, &types.InvalidParameterException{Message: aws.String(fmt.Sprintf("Unsupported auth flow %q", in.AuthFlow))}
+	}
+}
+
+// RespondToAuthChallenge implements [vueclient.IdentityProvider].
+func (s *Server) RespondToAuthChallenge(_ context.Context, in *cognitoidentityprovider.RespondToAuthChallengeInput, _ ...func(*cognitoidentityprovider.Options)) (*cognitoidentityprovider.RespondToAuthChallengeOutput, error) {
+	if aws.ToString(in.ClientId) != s.ClientID {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("User pool client " + aws.ToString(in.ClientId) + " does not exist.")}
+	}
+	r := in.ChallengeResponses
+	if in.ChallengeName == types.ChallengeNameTypePasswordVerifier {
+		return s.verifyPassword(r)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	username, ok := s.pending[aws.ToString(in.Session)]
+	delete(s.pending, aws.ToString(in.Session))
+	if !ok || username != r["USERNAME"] {
+		return nil, &types.NotAuthorizedException{Message: aws.String("Invalid session for the user.")}
+	}
+	switch in.ChallengeName {
+	case types.ChallengeNameTypeNewPasswordRequired:
+		if !s.PasswordReset[username] || r["NEW_PASSWORD"] == "" {
+			return nil, &types.InvalidPasswordException{Message: aws.String("Password does not conform to policy.")}
+		}
+		s.Users[username] = r["NEW_PASSWORD"]
+		delete(s.PasswordReset, username)
+	case types.ChallengeNameTypeSoftwareTokenMfa, types.ChallengeNameTypeSmsMfa:
+		if in.ChallengeName != s.mfaType() || s.MFA[username] == "" {
+			return nil, &types.InvalidParameterException{Message: aws.String(fmt.Sprintf("Unexpected challenge %q", in.ChallengeName))}
+		}
+		if r[string(in.ChallengeName)+"_CODE"] != s.MFA[username] {
+			return nil, &types.CodeMismatchException{Message: aws.String("Invalid code received for user")}
+		}
+		return &cognitoidentityprovider.RespondToAuthChallengeOutput{AuthenticationResult: s.issue(true)}, nil
+	default:
+		return nil, &types.InvalidParameterException{Message: aws.String(fmt.Sprintf("Unsupported challenge %q", in.ChallengeName))}
+	}
+	return s.next(username), nil
+}
+
+// verifyPassword checks the PASSWORD_VERIFIER response to an SRP exchange.
+func (s *Server) verifyPassword(r map[string]string) (*cognitoidentityprovider.RespondToAuthChallengeOutput, error) {
+	block := r["PASSWORD_CLAIM_SECRET_BLOCK"]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess, ok := s.sessions[block]
+	delete(s.sessions, block)
+	if !ok || sess.username != r["USERNAME"] {
+		return nil, &types.NotAuthorizedException{Message: aws.String("Invalid session for the user.")}
+	}
+	blockBytes, _ := base64.StdEncoding.DecodeString(block)
+	mac := hmac.New(sha256.New, sess.key)
+	mac.Write([]byte(poolName(s.UserPool) + sess.username))
+	mac.Write(blockBytes)
+	mac.Write([]byte(r["TIMESTAMP"]))
+	sig, err := base64.StdEncoding.DecodeString(r["PASSWORD_CLAIM_SIGNATURE"])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, &types.NotAuthorizedException{Message: aws.String("Incorrect username or password.")}
+	}
+	return s.next(sess.username), nil
+}
+
+// next issues the user's next challenge, or tokens if there are none left.
+// It must be called with mu held.
+func (s *Server) next(username string) *cognitoidentityprovider.RespondToAuthChallengeOutput {
+	var name types.ChallengeNameType
+	params := map[string]string{"USER_ID_FOR_SRP": username}
+	switch {
+	case s.PasswordReset[username]:
+		name = types.ChallengeNameTypeNewPasswordRequired
+		params["requiredAttributes"] = "[]"
+		params["userAttributes"] = "{}"
+	case s.MFA[username] != "":
+		name = s.mfaType()
+		if name == types.ChallengeNameTypeSmsMfa {
+			params["CODE_DELIVERY_DELIVERY_MEDIUM"] = "SMS"
+			params["CODE_DELIVERY_DESTINATION"] = "+*******0000"
+		}
+	default:
+		return &cognitoidentityprovider.RespondToAuthChallengeOutput{AuthenticationResult: s.issue(true)}
+	}
+	session := randomToken()
+	if s.pending == nil {
+		s.pending = map[string]string{}
+	}
+	s.pending[session] = username
+	return &cognitoidentityprovider.RespondToAuthChallengeOutput{
+		ChallengeName:       name,
+		ChallengeParameters: params,
+		Session:             aws.String(session),
+	}
+}
+
+func (s *Server) mfaType() types.ChallengeNameType {
+	if s.MFAType == "" {
+		return types.ChallengeNameTypeSoftwareTokenMfa
+	}
+	return s.MFAType
+}
+
+// startSRP answers the client's public value with the PASSWORD_VERIFIER challenge.
+func (s *Server) startSRP(params map[string]string) (*cognitoidentityprovider.InitiateAuthOutput, error) {
+	username := params["USERNAME"]
+	s.mu.Lock()
+	password, ok := s.Users[username]
+	s.mu.Unlock()
+	if !ok {
+		return nil, &types.UserNotFoundException{Message: aws.String("User does not exist.")}
+	}
+	A, ok := new(big.Int).SetString(params["SRP_A"], 16)
+	if !ok || new(big.Int).Mod(A, srpN).Sign() == 0 {
+		return nil, &types.InvalidParameterException{Message: aws.String("Invalid SRP_A")}
+	}
+
+	salt := new(big.Int).SetBytes(randomBytes(16))
+	x := hashInt(pad(salt), hashBytes([]byte(poolName(s.UserPool)+username+":"+password)))
+	v := new(big.Int).Exp(srpG, x, srpN)
+	b := new(big.Int).SetBytes(randomBytes(128))
+	// B = k*v + g^b
+	B := new(big.Int).Mul(srpK, v)
+	B.Add(B, new(big.Int).Exp(srpG, b, srpN))
+	B.Mod(B, srpN)
+	u := hashInt(pad(A), pad(B))
+	// S = (A * v^u)^b
+	S := new(big.Int).Exp(v, u, srpN)
+	S.Mul(S, A)
+	S.Exp(S, b, srpN)
+
+	block := base64.StdEncoding.EncodeToString(randomBytes(64))
+	s.mu.Lock()
+	if s.sessions == nil {
+		s.sessions = map[string]*srpSession{}
+	}
+	s.sessions[block] = &srpSession{username: username, key: hkdf(pad(S), pad(u))}
+	s.mu.Unlock()
+
+	return &cognitoidentityprovider.InitiateAuthOutput{
+		ChallengeName: types.ChallengeNameTypePasswordVerifier,
+		ChallengeParameters: map[string]string{
+			"USERNAME":        username,
+			"USER_ID_FOR_SRP": username,
+			"SALT":            salt.Text(16),
+			"SRP_B":           B.Text(16),
+			"SECRET_BLOCK":    block,
+		},
+	}, nil
+}
+
+// issue creates new tokens, with a refresh token for a new sign in.
+// It must be called with mu held.
+func (s *Server) issue(signIn bool) *types.AuthenticationResultType {
+	ttl := s.TokenTTL
+	if ttl == 0 {
+		ttl = time.Hour
+	}
+	res := &types.AuthenticationResultType{
+		AccessToken: aws.String(randomToken()),
+		IdToken:     aws.String(randomToken()),
+		TokenType:   aws.String("Bearer"),
+		ExpiresIn:   int32(ttl / time.Second),
+	}
+	if signIn {
+		res.RefreshToken = aws.String(randomToken())
+		if s.refresh == nil {
+			s.refresh = map[string]bool{}
+		}
+		s.refresh[*res.RefreshToken] = true
+	}
+	return res
+}
+
+// ServeHTTP implements the AWS JSON 1.1 protocol for the supported operations.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The SDK's input and output types carry the wire field names, so they are decoded and encoded as is.
+	var out any
+	var err error
+	switch target := r.Header.Get("X-Amz-Target"); target {
+	case "AWSCognitoIdentityProviderService.InitiateAuth":
+		var in cognitoidentityprovider.InitiateAuthInput
+		if err = json.NewDecoder(r.Body).Decode(&in); err == nil {
+			out, err = s.InitiateAuth(r.Context(), &in)
+		}
+	case "AWSCognitoIdentityProviderService.RespondToAuthChallenge":
+		var in cognitoidentityprovider.RespondToAuthChallengeInput
+		if err = json.NewDecoder(r.Body).Decode(&in); err == nil {
+			out, err = s.RespondToAuthChallenge(r.Context(), &in)
+		}
+	default:
+		err = &smithy.GenericAPIError{Code: "UnknownOperationException", Message: "unsupported target " + target}
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if err != nil {
+		code, msg := "SerializationException", err.Error()
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			code, msg = apiErr.ErrorCode(), apiErr.ErrorMessage()
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"__type": code, "message": msg})
+		return
+	}
+	json.NewEncoder(w).Encode(out)
+}
+
+var _ vueclient.IdentityProvider = (*Server)(nil)
+
+// SRP parameters, as used by Cognito: the 3072 bit group from RFC 5054 with k = H(N, g).
+var (
+	srpN, _ = new(big.Int).SetString(""+
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3D"+
+		"C2007CB8A163BF0598DA48361C55D39A69163FA8FD24CF5F"+
+		"83655D23DCA3AD961C62F356208552BB9ED529077096966D"+
+		"670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B"+
+		"E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9"+
+		"DE2BCBF6955817183995497CEA956AE515D2261898FA0510"+
+		"15728E5A8AAAC42DAD33170D04507A33A85521ABDF1CBA64"+
+		"ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7"+
+		"ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6B"+
+		"F12FFA06D98A0864D87602733EC86A64521F2B18177B200C"+
+		"BBE117577A615D6C770988C0BAD946E208E24FA074E5AB31"+
+		"43DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF", 16)
+	srpG = big.NewInt(2)
+	srpK = hashInt(pad(srpN), pad(srpG))
+)
+
+// pad encodes i as big-endian bytes with a leading zero byte if the top bit is set, so it is not read as negative.
+func pad(i *big.Int) []byte {
+	b := i.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
+
+func hashBytes(parts ...[]byte) []byte {
+	h := sha256.New()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+func hashInt(parts ...[]byte) *big.Int {
+	return new(big.Int).SetBytes(hashBytes(parts...))
+}
+
+// hkdf derives the 16 byte password authentication key.
+func hkdf(ikm, salt []byte) []byte {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(ikm)
+	mac = hmac.New(sha256.New, mac.Sum(nil))
+	mac.Write([]byte("Caldera Derived Key\x01"))
+	return mac.Sum(nil)[:16]
+}
+
+// poolName is the part of a pool ID after the region.
+func poolName(pool string) string {
+	_, name, _ := strings.Cut(pool, "_")
+	return name
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+func randomToken() string {
+	return hex.EncodeToString(randomBytes(16))
+}
@@ -1,21 +1,158 @@
 package vueclient
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"sync"
+	"time"
 
 	"golang.org/x/time/rate"
 )
 
-// throttledTransport is an http.RoundTripper that waits on a built in rate.Limiter for each request.
+// DefaultRate is the request rate used if a Throttle's Rate is zero.
+const DefaultRate rate.Limit = 10
+
+// ErrBudgetSpent is returned for requests beyond a Throttle's budget.
+var ErrBudgetSpent = errors.New("request budget spent")
+
+// Throttle limits the requests made to the Emporia API.
+// Its fields may be changed before the first request.
+//
+// In adaptive mode, the rate is halved whenever Emporia responds with 429 Too Many Requests or slower than SlowResponse,
+// down to MinRate, and grows back towards Rate by a small step with each other response.
+type Throttle struct {
+	// Rate is the maximum number of requests per second.  If zero, DefaultRate is used.
+	Rate rate.Limit
+
+	// Adaptive enables slowing down when Emporia appears overloaded.
+	Adaptive bool
+	// MinRate is the lowest rate adaptive mode slows down to.  If zero, a tenth of Rate is used.
+	MinRate rate.Limit
+	// SlowResponse is how long a response may take before adaptive mode considers it slow.
+	// If zero, 5 seconds is used.
+	SlowResponse time.Duration
+
+	once    sync.Once
+	limiter *rate.Limiter
+
+	mu        sync.Mutex
+	requests  int  // Requests made.
+	limited   bool // Whether there is a budget.
+	remaining int  // Requests left in the budget.
+}
+
+func (t *Throttle) rate() rate.Limit {
+	if t.Rate > 0 {
+		return t.Rate
+	}
+	return DefaultRate
+}
+
+func (t *Throttle) minRate() rate.Limit {
+	if t.MinRate > 0 {
+		return min(t.MinRate, t.rate())
+	}
+	return t.rate() / 10
+}
+
+func (t *Throttle) slowResponse() time.Duration {
+	if t.SlowResponse > 0 {
+		return t.SlowResponse
+	}
+	return 5 * time.Second
+}
+
+func (t *Throttle) init() {
+	t.once.Do(func() { t.limiter = rate.NewLimiter(t.rate(), 1) })
+}
+
+// SetBudget allows n more requests, after which requests fail with ErrBudgetSpent.
+// A negative n removes the budget.
+func (t *Throttle) SetBudget(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.limited, t.remaining = n >= 0, max(n, 0)
+}
+
+// Remaining returns the number of requests left in the budget, and whether there is a budget at all.
+func (t *Throttle) Remaining() (n int, limited bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.remaining, t.limited
+}
+
+// Requests returns the number of requests made.
+func (t *Throttle) Requests() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.requests
+}
+
+// CurrentRate returns the rate requests are currently limited to.
+func (t *Throttle) CurrentRate() rate.Limit {
+	t.init()
+	return t.limiter.Limit()
+}
+
+// take uses a request from the budget.
+func (t *Throttle) take() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.limited {
+		if t.remaining == 0 {
+			return ErrBudgetSpent
+		}
+		t.remaining--
+	}
+	t.requests++
+	return nil
+}
+
+// observe adjusts the rate after a response that took d.
+func (t *Throttle) observe(rep *http.Response, d time.Duration) {
+	if !t.Adaptive {
+		return
+	}
+	cur := t.limiter.Limit()
+	switch {
+	case rep.StatusCode == http.StatusTooManyRequests || d > t.slowResponse():
+		if next := max(cur/2, t.minRate()); next < cur {
+			log.Printf("Emporia responded with %s in %v; slowing down to %.3g requests/s", rep.Status, d.Round(time.Millisecond), float64(next))
+			t.limiter.SetLimit(next)
+		}
+	case cur < t.rate():
+		// Recover by a hundredth of the maximum rate per response.
+		t.limiter.SetLimit(min(cur+t.rate()/100, t.rate()))
+	}
+}
+
+// throttledTransport is an http.RoundTripper that waits on a Throttle for each request.
 type throttledTransport struct {
-	Base    http.RoundTripper
-	Limiter *rate.Limiter
+	Base     http.RoundTripper
+	Throttle *Throttle
 }
 
 func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if err := t.Limiter.Wait(req.Context()); err != nil {
+	t.Throttle.init()
+	if err := t.Throttle.limiter.Wait(req.Context()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
 		return nil, fmt.Errorf("limiter: %w", err)
 	}
-	return t.Base.RoundTrip(req)
+	// Only requests that are sent use the budget, not those canceled while waiting.
+	if err := t.Throttle.take(); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	start := time.Now()
+	rep, err := t.Base.RoundTrip(req)
+	if err == nil {
+		t.Throttle.observe(rep, time.Since(start))
+	}
+	return rep, err
 }
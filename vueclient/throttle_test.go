@@ -0,0 +1,89 @@
+package vueclient_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// newThrottledClient returns a client of a server that calls handle for each request.
+func newThrottledClient(t *testing.T, handle func(w http.ResponseWriter, n int)) *vueclient.Client {
+	t.Helper()
+	var n atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handle(w, int(n.Add(1)))
+	}))
+	t.Cleanup(srv.Close)
+	tok := vueclient.NewAtom(&vueclient.Token{
+		Token:   oauth2.Token{AccessToken: "access", Expiry: time.Now().Add(time.Hour)},
+		IDToken: "id",
+	})
+	c := vueclient.NewClient(tok, nil)
+	c.BaseURL, _ = url.Parse(srv.URL)
+	c.Throttle.Rate = 1000
+	return c
+}
+
+func TestThrottle_Adaptive(t *testing.T) {
+	c := newThrottledClient(t, func(w http.ResponseWriter, n int) {
+		switch {
+		case n <= 3:
+			w.WriteHeader(http.StatusTooManyRequests)
+		case n == 4:
+			time.Sleep(50 * time.Millisecond)
+			w.Write([]byte(`{}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	})
+	c.Throttle.Adaptive = true
+	c.Throttle.SlowResponse = 20 * time.Millisecond
+	for range 3 {
+		if _, err := c.GetDevices(); err == nil {
+			t.Fatalf("GetDevices() succeeded, want an error for 429")
+		}
+	}
+	if got, want := c.Throttle.CurrentRate(), rate.Limit(125); got != want {
+		t.Errorf("CurrentRate() after 3 429s = %v, want %v", got, want)
+	}
+	if _, err := c.GetDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Throttle.CurrentRate(), rate.Limit(100); got != want {
+		t.Errorf("CurrentRate() after a slow response = %v, want the minimum %v", got, want)
+	}
+	if _, err := c.GetDevices(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.Throttle.CurrentRate(), rate.Limit(110); got != want {
+		t.Errorf("CurrentRate() after a good response = %v, want %v", got, want)
+	}
+}
+
+func TestThrottle_Budget(t *testing.T) {
+	c := newThrottledClient(t, func(w http.ResponseWriter, n int) { w.Write([]byte(`{}`)) })
+	c.Throttle.SetBudget(2)
+	for range 2 {
+		if _, err := c.GetDevices(); err != nil {
+			t.Fatalf("GetDevices() error = %v", err)
+		}
+	}
+	if _, err := c.GetDevices(); !errors.Is(err, vueclient.ErrBudgetSpent) {
+		t.Errorf("GetDevices() error = %v, want ErrBudgetSpent", err)
+	}
+	if n, limited := c.Throttle.Remaining(); n != 0 || !limited {
+		t.Errorf("Remaining() = %d, %v, want 0, true", n, limited)
+	}
+	if got := c.Throttle.Requests(); got != 2 {
+		t.Errorf("Requests() = %d, want 2", got)
+	}
+}
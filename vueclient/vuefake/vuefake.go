@@ -0,0 +1,414 @@
+// Package vuefake implements a fake Emporia Vue API server with deterministic synthetic data.
+//
+// It serves the subset of the API used by [vueclient]: /customers/devices, /customers/devices/status,
+// /devices/outlet and the getChartUsage and getDeviceListUsages methods of /AppAPI.
+// Smart plugs, whose model starts with SSO, are outlets that can be turned on and off,
+// and EV chargers, whose model starts with VVD, can be paused and have their charging rate set through /devices/evcharger.
+// Requests must carry an authtoken header, but its value is not checked.
+package vuefake
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"sgrankin.dev/vuescrape/vueclient"
+)
+
+// Server is a fake Emporia API.
+type Server struct {
+	// Devices is the customer's device tree.
+	// If nil, [DefaultDevices] is used.
+	Devices []vueclient.Device
+	// Now returns the current time; there is no usage after it.
+	// If nil, time.Now is used.
+	Now func() time.Time
+	// Offline holds the devices that are disconnected, and when they were last seen.
+	Offline map[vueclient.DeviceGID]time.Time
+
+	mu       sync.Mutex
+	outlets  map[vueclient.DeviceGID]*vueclient.Outlet    // Outlets that have been set.
+	chargers map[vueclient.DeviceGID]*vueclient.EVCharger // Chargers that have been updated.
+}
+
+// DefaultDevices returns a Vue 2 monitor with a nested smart plug, and an EV charger.
+func DefaultDevices() []vueclient.Device {
+	mon := vueclient.Device{DeviceGID: 1000, ManufacturerDeviceID: "A2107A04B1B2C3D4", Model: "VUE002", Firmware: "Vue2-1.2.3"}
+	mon.Channels = append(mon.Channels, vueclient.Channel{DeviceGID: 1000, ChannelNum: "1,2,3", ChannelMultiplier: 1})
+	for i := 1; i <= 4; i++ {
+		mon.Channels = append(mon.Channels, vueclient.Channel{
+			Name:              fmt.Sprintf("Circuit %d", i),
+			DeviceGID:         1000,
+			ChannelNum:        strconv.Itoa(i),
+			ChannelMultiplier: 1,
+		})
+	}
+	mon.Channels = append(mon.Channels, vueclient.Channel{Name: "Balance", DeviceGID: 1000, ChannelNum: "Balance", ChannelMultiplier: 1})
+	mon.Devices = []vueclient.Device{{
+		DeviceGID:            1001,
+		ManufacturerDeviceID: "B1234A5B6C7D8E9F",
+		Model:                "SSO001",
+		Firmware:             "SSO-1.4.0",
+		Channels:             []vueclient.Channel{{Name: "Plug", DeviceGID: 1001, ChannelNum: "1,2,3", ChannelMultiplier: 1}},
+	}}
+	charger := vueclient.Device{
+		DeviceGID:            1002,
+		ManufacturerDeviceID: "C0FFEE0123456789",
+		Model:                "VVDN01",
+		Firmware:             "EVC-2.0.1",
+		Channels:             []vueclient.Channel{{Name: "EV Charger", DeviceGID: 1002, ChannelNum: "1,2,3", ChannelMultiplier: 1}},
+	}
+	return []vueclient.Device{mon, charger}
+}
+
+// Usage returns the synthetic energy use in kWh of a channel over the bucket of size d starting at ts.
+// It returns nil for the buckets that are left as gaps in the data.
+func Usage(device vueclient.DeviceGID, channel string, ts time.Time, d time.Duration) *float64 {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d/%s", device, channel)
+	seed := h.Sum64()
+	fmt.Fprintf(h, "/%d/%d", ts.Unix(), d)
+	if h.Sum64()%97 == 0 {
+		return nil
+	}
+	// A daily cycle of power use, in kW, shifted per channel.
+	phase := float64(seed%1000) / 1000
+	day := float64(ts.Unix()%86400) / 86400
+	kw := 0.5 + float64(seed%7)/10 + 0.4*math.Sin(2*math.Pi*(day+phase))
+	kwh := kw * d.Hours()
+	return &kwh
+}
+
+func (s *Server) devices() []vueclient.Device {
+	if s.Devices != nil {
+		return s.Devices
+	}
+	return DefaultDevices()
+}
+
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("authtoken") == "" {
+		http.Error(w, "missing authtoken", http.StatusUnauthorized)
+		return
+	}
+	var resp any
+	var err error
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/customers/devices":
+		resp = map[string]any{"devices": s.devices()}
+	case r.Method == "GET" && r.URL.Path == "/customers/devices/status":
+		resp = s.status()
+	case r.Method == "PUT" && r.URL.Path == "/devices/outlet":
+		resp, err = s.setOutlet(r)
+	case r.Method == "PUT" && r.URL.Path == "/devices/evcharger":
+		resp, err = s.updateCharger(r)
+	case r.Method == "GET" && r.URL.Path == "/AppAPI":
+		switch m := r.URL.Query().Get("apiMethod"); m {
+		case "getChartUsage":
+			resp, err = s.chartUsage(r)
+		case "getDeviceListUsages":
+			resp, err = s.deviceListUsages(r)
+		default:
+			err = fmt.Errorf("unsupported apiMethod %q", m)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// neverOffline is the offlineSince Emporia reports for connected devices.
+var neverOffline = time.Date(1999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (s *Server) status() *vueclient.DevicesStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := &vueclient.DevicesStatus{}
+	var walk func(parent *vueclient.Device, devs []vueclient.Device)
+	walk = func(parent *vueclient.Device, devs []vueclient.Device) {
+		for _, dev := range devs {
+			conn := vueclient.DeviceConnected{DeviceGID: dev.DeviceGID, Connected: true, OfflineSince: neverOffline}
+			if since, ok := s.Offline[dev.DeviceGID]; ok {
+				conn.Connected, conn.OfflineSince = false, since
+			}
+			status.DevicesConnected = append(status.DevicesConnected, conn)
+			if isOutlet(dev) {
+				status.Outlets = append(status.Outlets, *s.outlet(parent, dev.DeviceGID))
+			}
+			if isCharger(dev) {
+				status.EVChargers = append(status.EVChargers, *s.charger(dev.DeviceGID))
+			}
+			walk(&dev, dev.Devices)
+		}
+	}
+	walk(nil, s.devices())
+	return status
+}
+
+func isOutlet(dev vueclient.Device) bool { return strings.HasPrefix(dev.Model, "SSO") }
+
+func isCharger(dev vueclient.Device) bool { return strings.HasPrefix(dev.Model, "VVD") }
+
+// outlet returns the state of an outlet plugged into a channel of parent, if it is set.  s.mu must be held.
+func (s *Server) outlet(parent *vueclient.Device, gid vueclient.DeviceGID) *vueclient.Outlet {
+	if o, ok := s.outlets[gid]; ok {
+		return o
+	}
+	o := &vueclient.Outlet{
+		DeviceGID: gid,
+		LoadGID:   int(gid) * 10,
+		OutletOn:  true,
+		Schedules: json.RawMessage(`[]`),
+	}
+	if parent != nil {
+		o.ParentDeviceGID, o.ParentChannelNum = parent.DeviceGID, "1,2,3"
+	}
+	return o
+}
+
+// charger returns the state of a charger.  s.mu must be held.
+func (s *Server) charger(gid vueclient.DeviceGID) *vueclient.EVCharger {
+	if ch, ok := s.chargers[gid]; ok {
+		return ch
+	}
+	return chargerStatus(&vueclient.EVCharger{
+		DeviceGID:       gid,
+		LoadGID:         int(gid) * 10,
+		ChargerOn:       true,
+		ChargingRate:    32,
+		MaxChargingRate: 40,
+	})
+}
+
+// chargerStatus fills in the status of a charger from its settings.
+func chargerStatus(ch *vueclient.EVCharger) *vueclient.EVCharger {
+	ch.Status, ch.Icon, ch.IconLabel = "Charging", "CarCharging", "Charging"
+	ch.Message = fmt.Sprintf("Charging at %d amps", ch.ChargingRate)
+	if !ch.ChargerOn {
+		ch.Status, ch.Icon, ch.IconLabel = "Standby", "CarConnected", "Paused"
+		ch.Message = "Charging is paused"
+	}
+	return ch
+}
+
+func (s *Server) updateCharger(r *http.Request) (any, error) {
+	var ch vueclient.EVCharger
+	if err := json.NewDecoder(r.Body).Decode(&ch); err != nil {
+		return nil, fmt.Errorf("bad charger: %w", err)
+	}
+	dev, ok := findDevice(s.devices(), ch.DeviceGID)
+	if !ok || !isCharger(dev) {
+		return nil, fmt.Errorf("no EV charger %d", ch.DeviceGID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.charger(ch.DeviceGID)
+	if ch.MaxChargingRate != cur.MaxChargingRate {
+		return nil, errors.New("the maximum charging rate can only be changed in the app")
+	}
+	if ch.ChargingRate < 6 || ch.ChargingRate > ch.MaxChargingRate {
+		return nil, fmt.Errorf("charging rate %d is outside 6-%d amps", ch.ChargingRate, ch.MaxChargingRate)
+	}
+	if s.chargers == nil {
+		s.chargers = map[vueclient.DeviceGID]*vueclient.EVCharger{}
+	}
+	s.chargers[ch.DeviceGID] = chargerStatus(&ch)
+	return &ch, nil
+}
+
+func (s *Server) setOutlet(r *http.Request) (any, error) {
+	var outlet vueclient.Outlet
+	if err := json.NewDecoder(r.Body).Decode(&outlet); err != nil {
+		return nil, fmt.Errorf("bad outlet: %w", err)
+	}
+	var cur *vueclient.Outlet
+	for _, o := range s.status().Outlets {
+		if o.DeviceGID == outlet.DeviceGID {
+			cur = &o
+		}
+	}
+	if cur == nil {
+		return nil, fmt.Errorf("no outlet %d", outlet.DeviceGID)
+	}
+	// The body replaces all the plug's settings, so one without them is most likely a mistake.
+	if outlet.LoadGID != cur.LoadGID || outlet.ParentDeviceGID != cur.ParentDeviceGID || outlet.Schedules == nil {
+		return nil, fmt.Errorf("outlet %d settings are missing", outlet.DeviceGID)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.outlets == nil {
+		s.outlets = map[vueclient.DeviceGID]*vueclient.Outlet{}
+	}
+	s.outlets[outlet.DeviceGID] = &outlet
+	return &outlet, nil
+}
+
+func (s *Server) chartUsage(r *http.Request) (any, error) {
+	q := r.URL.Query()
+	gid, err := strconv.Atoi(q.Get("deviceGid"))
+	if err != nil {
+		return nil, fmt.Errorf("bad deviceGid: %w", err)
+	}
+	ch, err := s.channel(vueclient.DeviceGID(gid), q.Get("channel"))
+	if err != nil {
+		return nil, err
+	}
+	start, err := time.Parse(time.RFC3339, q.Get("start"))
+	if err != nil {
+		return nil, fmt.Errorf("bad start: %w", err)
+	}
+	end, err := time.Parse(time.RFC3339, q.Get("end"))
+	if err != nil {
+		return nil, fmt.Errorf("bad end: %w", err)
+	}
+	scale := vueclient.Scale(q.Get("scale"))
+	d, page, err := scaleSizes(scale)
+	if err != nil {
+		return nil, err
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("end %s is before start %s", end, start)
+	}
+	if end.Sub(start) > page {
+		return nil, fmt.Errorf("range %s exceeds the page size %s for scale %s", end.Sub(start), page, scale)
+	}
+	if unit := vueclient.EnergyUnit(q.Get("energyUnit")); unit != vueclient.EnergyKWh {
+		return nil, fmt.Errorf("unsupported energyUnit %q", unit)
+	}
+
+	// Buckets are aligned to the scale, and only complete buckets are returned.
+	first := start.UTC().Truncate(d)
+	n := int(end.Sub(first) / d)
+	now := s.now()
+	usage := make([]*float64, n)
+	for i := range usage {
+		ts := first.Add(time.Duration(i) * d)
+		if ts.Add(d).After(now) {
+			continue
+		}
+		usage[i] = Usage(ch.DeviceGID, ch.ChannelNum, ts, d)
+	}
+	return map[string]any{
+		"firstUsageInstant": first,
+		"usageList":         usage,
+	}, nil
+}
+
+func (s *Server) deviceListUsages(r *http.Request) (any, error) {
+	q := r.URL.Query()
+	instant, err := time.Parse(time.RFC3339, q.Get("instant"))
+	if err != nil {
+		return nil, fmt.Errorf("bad instant: %w", err)
+	}
+	scale := vueclient.Scale(q.Get("scale"))
+	d, _, err := scaleSizes(scale)
+	if err != nil {
+		return nil, err
+	}
+	if unit := vueclient.EnergyUnit(q.Get("energyUnit")); unit != vueclient.EnergyKWh {
+		return nil, fmt.Errorf("unsupported energyUnit %q", unit)
+	}
+	// The last complete bucket before the instant.
+	bucket := instant.UTC().Truncate(d).Add(-d)
+	var devices []any
+	for _, f := range strings.FieldsFunc(q.Get("deviceGids"), func(r rune) bool { return r == ' ' || r == '+' || r == ',' }) {
+		gid, err := strconv.Atoi(f)
+		if err != nil {
+			return nil, fmt.Errorf("bad deviceGids: %w", err)
+		}
+		dev, ok := findDevice(s.devices(), vueclient.DeviceGID(gid))
+		if !ok {
+			return nil, fmt.Errorf("unknown device %d", gid)
+		}
+		devices = append(devices, deviceUsage(dev, bucket, d))
+	}
+	return map[string]any{
+		"deviceListUsages": map[string]any{
+			"instant": bucket,
+			"scale":   scale,
+			"devices": devices,
+		},
+	}, nil
+}
+
+// deviceUsage reports the usage of every channel of dev.  Nested devices are reported under the main channel.
+func deviceUsage(dev vueclient.Device, ts time.Time, d time.Duration) map[string]any {
+	var usages []map[string]any
+	for _, ch := range dev.Channels {
+		u := map[string]any{
+			"name":          ch.Name,
+			"channelNum":    ch.ChannelNum,
+			"usage":         0.0,
+			"nestedDevices": []any{},
+		}
+		if v := Usage(dev.DeviceGID, ch.ChannelNum, ts, d); v != nil {
+			u["usage"] = *v
+		}
+		if ch.ChannelNum == "1,2,3" {
+			var nested []any
+			for _, sub := range dev.Devices {
+				nested = append(nested, deviceUsage(sub, ts, d))
+			}
+			if nested != nil {
+				u["nestedDevices"] = nested
+			}
+		}
+		usages = append(usages, u)
+	}
+	return map[string]any{"deviceGid": dev.DeviceGID, "channelUsages": usages}
+}
+
+func (s *Server) channel(gid vueclient.DeviceGID, num string) (vueclient.Channel, error) {
+	dev, ok := findDevice(s.devices(), gid)
+	if !ok {
+		return vueclient.Channel{}, fmt.Errorf("unknown device %d", gid)
+	}
+	for _, ch := range dev.Channels {
+		if ch.ChannelNum == num {
+			return ch, nil
+		}
+	}
+	return vueclient.Channel{}, fmt.Errorf("unknown channel %q of device %d", num, gid)
+}
+
+func findDevice(devs []vueclient.Device, gid vueclient.DeviceGID) (vueclient.Device, bool) {
+	for _, dev := range devs {
+		if dev.DeviceGID == gid {
+			return dev, true
+		}
+		if sub, ok := findDevice(dev.Devices, gid); ok {
+			return sub, true
+		}
+	}
+	return vueclient.Device{}, false
+}
+
+// scaleSizes returns the bucket and page sizes of a scale, or an error if they are not known.
+func scaleSizes(scale vueclient.Scale) (d, page time.Duration, err error) {
+	page, ok := scale.LookupPageSize()
+	if !ok {
+		return 0, 0, fmt.Errorf("unsupported scale %q", scale)
+	}
+	return scale.Duration(), page, nil
+}